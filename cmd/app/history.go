@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	history "github.com/brchri/tesla-youq/internal/history"
+	logger "github.com/sirupsen/logrus"
+)
+
+// queries the event history database and prints matching events, e.g.
+// tesla-youq history -c config.yml --car 1 --since 24h
+func runHistoryCommand(args []string) {
+	var filter history.Filter
+	var dbFile, since, until string
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "location of config file")
+	flags.StringVar(&configFile, "c", "", "location of config file")
+	flags.StringVar(&dbFile, "db", "", "location of history database; overrides history_db in config file")
+	flags.IntVar(&filter.CarID, "car", 0, "only show events for this teslamate car id")
+	flags.StringVar(&filter.Door, "door", "", "only show events for this garage door name")
+	flags.StringVar(&filter.Type, "type", "", "only show events of this type, e.g. opener_response")
+	flags.StringVar(&since, "since", "", "only show events after this time, as RFC3339 (2006-01-02T15:04:05Z07:00) or a duration before now (24h)")
+	flags.StringVar(&until, "until", "", "only show events before this time, as RFC3339 or a duration before now")
+	flags.IntVar(&filter.Limit, "limit", 100, "maximum number of most recent events to show; 0 for no limit")
+	flags.Parse(args)
+
+	if dbFile == "" {
+		if configFile == "" {
+			configFile = os.Getenv("CONFIG_FILE")
+		}
+		if configFile == "" {
+			logger.Fatal("History database must be defined with '--db', or with history_db in a config file defined with '-c' or 'CONFIG_FILE' environment variable")
+		}
+		dbFile = loadConfig().Global.HistoryDB
+		if dbFile == "" {
+			logger.Fatalf("history_db is not defined in config file %s", configFile)
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseHistoryTime(since); err != nil {
+		logger.Fatalf("Invalid --since value: %v", err)
+	}
+	if filter.Until, err = parseHistoryTime(until); err != nil {
+		logger.Fatalf("Invalid --until value: %v", err)
+	}
+
+	db, err := history.Open(dbFile, 0)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer db.Close()
+
+	results, err := db.Query(filter)
+	if err != nil {
+		logger.Fatalf("Unable to query history: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tCAR\tDOOR\tACTION\tRESULT\tLOCATION\tMESSAGE")
+	for _, e := range results {
+		location := ""
+		if e.Lat != 0 || e.Lng != 0 {
+			location = fmt.Sprintf("%f,%f", e.Lat, e.Lng)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", e.Time.Format("01/02/2006 15:04:05"), e.Type, e.CarID, e.Door, e.Action, e.Result, location, e.Message)
+	}
+	w.Flush()
+}
+
+// parses an RFC3339 timestamp or a duration relative to now; returns a zero time for an empty value
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not an RFC3339 time or duration", value)
+	}
+	return t, nil
+}
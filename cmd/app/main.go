@@ -1,7 +1,7 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -11,10 +11,19 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	api "github.com/brchri/tesla-youq/internal/api"
+	calendar "github.com/brchri/tesla-youq/internal/calendar"
 	geo "github.com/brchri/tesla-youq/internal/geo"
+	history "github.com/brchri/tesla-youq/internal/history"
+	mqtt5 "github.com/brchri/tesla-youq/internal/mqtt5"
+	notify "github.com/brchri/tesla-youq/internal/notify"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	publisher "github.com/brchri/tesla-youq/internal/publisher"
+	state "github.com/brchri/tesla-youq/internal/state"
 	"github.com/google/uuid"
 	logger "github.com/sirupsen/logrus"
 
@@ -23,34 +32,119 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var subscribeRetryDelay = 5 * time.Second // delay between attempts to subscribe to a topic
+
+// messages buffered for the main loop, e.g. while it reloads the config, before the client's handlers block; handlers
+// are called in order, so a blocked handler holds up the messages behind it
+const messageBuffer = 100
+
 var (
 	configFile  string
-	cars        []*util.Car                  // list of all cars from all garage doors
-	version     string            = "v0.0.1" // pass -ldflags="-X main.version=<version>" at build time to set linker flag and bake in binary version
-	messageChan chan mqtt.Message            // channel to receive mqtt messages
+	testingFlag bool              // set by -testing; overrides the config's testing value
+	version     string = "v0.0.1" // pass -ldflags="-X main.version=<version>" at build time to set linker flag and bake in binary version
 )
 
+// running state of the app, created from the loaded config by newApp
+type app struct {
+	controller  *geo.Controller      // operates garage doors through the myq session
+	messageChan chan receivedMessage // channel to receive mqtt messages
+	messageStop chan struct{}        // closed on shutdown so handlers stop sending to messageChan
+	apiServer   *api.Server          // serves metrics and health endpoints
+	statePub    *publisher.Publisher // publishes app state and door actions to mqtt
+	haDiscovery *publisher.Discovery // announces entities to home assistant; nil if disabled
+	stateStore  *state.Store         // persists car and garage door state; nil if disabled
+	historyDB   *history.DB          // records event history; nil if disabled
+
+	stopScheduledCloses func() // stops watching for close_if_open_at times, e.g. before the config is reloaded
+
+	mutex    sync.RWMutex        // guards config, cars, and carsByID, which are replaced together when the config is reloaded
+	config   *util.ConfigStruct  // running config; never modified once loaded, a reload replaces it
+	cars     []*util.Car         // list of all cars from all garage doors
+	carsByID map[int][]*util.Car // cars by teslamate car id; a car id can belong to more than one garage door
+}
+
 func init() {
 	logger.SetFormatter(&util.CustomFormatter{})
 	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
 		logger.SetLevel(logger.DebugLevel)
 	}
 	log.SetOutput(os.Stdout)
-	parseArgs()
-	util.LoadConfig(configFile)
-	checkEnvVars()
-	for _, garageDoor := range util.Config.GarageDoors {
+}
+
+// creates the app for a loaded config, starting location processing for each car and restoring car and garage door
+// state from before the last restart
+func newApp(config *util.ConfigStruct, controller *geo.Controller) *app {
+	a := &app{
+		controller:  controller,
+		messageChan: make(chan receivedMessage, messageBuffer),
+		messageStop: make(chan struct{}),
+	}
+	var cars []*util.Car
+	for _, garageDoor := range config.GarageDoors {
 		for _, car := range garageDoor.Cars {
 			car.GarageDoor = garageDoor
 			cars = append(cars, car)
-			if car.GarageDoor.GeofenceType == util.PolygonGeofenceType {
-				car.InsidePolyCloseGeo = true
-				car.InsidePolyOpenGeo = true
+			a.startCar(car)
+		}
+	}
+	a.setConfig(config, cars)
+
+	// restore car and garage door state from before the last restart
+	if config.Global.StateFile != "" {
+		s, err := state.Load(config.Global.StateFile, time.Duration(config.Global.StateMaxAge)*time.Minute)
+		if err != nil {
+			logger.Warnf("Unable to restore state, starting fresh: %v", err)
+		} else {
+			for garageDoor, until := range s.Apply(cars, config.GarageDoors) {
+				geo.ResumeCooldown(garageDoor, until)
 			}
-			// start listening to car update location channels
-			go processLocationUpdates(car)
 		}
 	}
+	return a
+}
+
+// replaces the running config and cars, indexing cars by teslamate car id for routing messages
+func (a *app) setConfig(config *util.ConfigStruct, cars []*util.Car) {
+	carsByID := map[int][]*util.Car{}
+	for _, car := range cars {
+		carsByID[car.ID] = append(carsByID[car.ID], car)
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.config = config
+	a.cars = cars
+	a.carsByID = carsByID
+}
+
+// returns the running config
+func (a *app) currentConfig() *util.ConfigStruct {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.config
+}
+
+// returns all cars from all garage doors
+func (a *app) currentCars() []*util.Car {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.cars
+}
+
+// returns the cars with a teslamate car id
+func (a *app) carsWithID(carID int) []*util.Car {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.carsByID[carID]
+}
+
+// loads and validates the config file, exiting if it's invalid
+func loadConfig() *util.ConfigStruct {
+	c, err := util.Load(configFile)
+	if err != nil {
+		logger.Fatalf("Unable to load config file: %v", err)
+	}
+	c.Testing = testingFlag
+	return c
 }
 
 // parse args
@@ -60,7 +154,7 @@ func parseArgs() {
 	var getVersion bool
 	flag.StringVar(&configFile, "config", "", "location of config file")
 	flag.StringVar(&configFile, "c", "", "location of config file")
-	flag.BoolVar(&util.Config.Testing, "testing", false, "test case")
+	flag.BoolVar(&testingFlag, "testing", false, "test case")
 	flag.BoolVar(&getDevices, "d", false, "get myq devices")
 	flag.BoolVar(&getVersion, "v", false, "print version info and return")
 	flag.BoolVar(&getVersion, "version", false, "print version info and return")
@@ -89,218 +183,465 @@ func parseArgs() {
 		}
 	} else {
 		// if -d flag passed, get devices and exit
-		checkEnvVars()
-		geo.GetGarageDoorSerials(util.Config)
+		config := &util.ConfigStruct{}
+		checkEnvVars(config)
+		geo.GetGarageDoorSerials(*config)
 		os.Exit(0)
 	}
 }
 
 func main() {
-	messageChan = make(chan mqtt.Message)
+	// run subcommands and exit
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			runHistoryCommand(os.Args[2:])
+			return
+		case "validate":
+			runValidateCommand(os.Args[2:])
+			return
+		case pause.CommandPause, pause.CommandResume:
+			runPauseCommand(os.Args[1], os.Args[2:])
+			return
+		}
+	}
+	parseArgs()
+	config := loadConfig()
+	checkEnvVars(config)
+	newApp(config, geo.NewMyqController()).run()
+}
+
+// connects to the mqtt broker and processes messages until interrupted, reloading the config when it changes
+func (a *app) run() {
+	config := a.currentConfig()
+
+	// set conditional MQTT client opts
+	clientID := config.Global.MqttClientID
+	if clientID == "" {
+		// generate UUID for mqtt client connection if not specified in config file
+		clientID = uuid.New().String()
+	}
+	opts, err := newMqttClientOptions(config, clientID)
+	if err != nil {
+		logger.Fatalf("Invalid MQTT TLS config: %v", err)
+	}
+	opts.OnConnect = a.onMqttConnect
+	// mark app unavailable if the connection is lost without a clean disconnect
+	opts.SetWill(publisher.AvailabilityTopic(config.Global.MqttTopicPrefix), publisher.AvailabilityOffline, 1, true)
+
+	// create a new MQTT client object
+	client := newMqttClient(config, opts)
+
+	// publish app state and door actions back to mqtt
+	a.statePub = publisher.New(client, config.Global.MqttTopicPrefix, a.currentCars())
+	a.statePub.Start()
+	if config.Global.HaDiscovery {
+		a.haDiscovery = publisher.NewDiscovery(a.statePub, config, a.controller, version)
+	}
+
+	// record event history if enabled
+	if config.Global.HistoryDB != "" {
+		var err error
+		a.historyDB, err = history.Open(config.Global.HistoryDB, time.Duration(config.Global.HistoryRetention)*24*time.Hour)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		a.historyDB.Record()
+		logger.Infof("Recording event history to %s", config.Global.HistoryDB)
+	}
+
+	// send notifications of door actions and failures if enabled
+	if len(config.Global.Notifications) > 0 {
+		notifier, err := notify.New(config.Global.Notifications)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for _, g := range config.GarageDoors {
+			if g.ConfirmClose {
+				// buttons call the control api, which is only enabled with an api token
+				publicUrl := config.Global.PublicUrl
+				if config.Global.ApiToken == "" {
+					publicUrl = ""
+				}
+				notifier.EnableConfirmations(publicUrl, geo.ConfirmationNonce, geo.ResolveConfirmation)
+				break
+			}
+		}
+		notifier.Start()
+		logger.Infof("Sending notifications to %d notification sink(s)", len(config.Global.Notifications))
+	}
+
+	// persist car and garage door state on change if enabled
+	if config.Global.StateFile != "" {
+		a.stateStore = state.NewStore(config.Global.StateFile, a.currentCars(), config.GarageDoors)
+		a.stateStore.Watch()
+	}
+
+	// close garage doors left open at their close_if_open_at time
+	a.stopScheduledCloses = a.controller.WatchScheduledCloses(*config)
+
+	// pause automation during calendar events; started after state is restored so stale calendar pauses are removed
+	if len(config.Global.Calendars) > 0 {
+		calendar.Watch(config.Global.Calendars)
+		logger.Infof("Watching %d calendar(s) for events that pause automation", len(config.Global.Calendars))
+	}
 
+	// serve http endpoints if enabled
+	a.apiServer = api.NewServer(config, a.currentCars(), a.controller, client)
+	if config.Global.HttpPort > 0 {
+		go func() {
+			if err := a.apiServer.ListenAndServe(fmt.Sprintf(":%d", config.Global.HttpPort)); err != nil {
+				logger.Errorf("Http server stopped: %v", err)
+			}
+		}()
+	}
+
+	// connect to the MQTT broker
+	logger.Debug("Connecting to MQTT broker")
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		logger.Fatalf("could not connect to mqtt broker: %v", token.Error())
+	} else {
+		logger.Info("Connected to MQTT broker")
+	}
+	logger.Debugf("MQTT Broker Connected: %t", client.IsConnected())
+
+	// listen for incoming messages until interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// reload the config when it or a kml file it references changes, or on SIGHUP
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	watchedFiles := statConfigFiles(*config)
+	reloadPending := false // a reload was deferred while a garage door was operating and is retried on the next poll
+	configPoll := time.NewTicker(configPollInterval)
+	defer configPoll.Stop()
+
+	for {
+		select {
+		case message := <-a.messageChan:
+			a.routeMessage(message)
+
+		case <-configPoll.C:
+			if reloadPending {
+				logger.Info("Retrying deferred config reload")
+			} else if watchedFiles.changed() {
+				logger.Info("Config file changed, reloading")
+			} else {
+				continue
+			}
+			if reloadPending = a.reloadOrDefer(client); !reloadPending {
+				watchedFiles = statConfigFiles(*a.currentConfig())
+			}
+
+		case <-reloadChannel:
+			logger.Info("Received hangup signal, reloading config")
+			if reloadPending = a.reloadOrDefer(client); !reloadPending {
+				watchedFiles = statConfigFiles(*a.currentConfig())
+			}
+
+		case <-ctx.Done():
+			stop() // a second interrupt terminates immediately
+			a.shutdown(client)
+			return
+
+		}
+	}
+}
+
+// returns mqtt client options for connecting to the broker defined in the config; returns an error if the ca bundle or
+// client certificate can't be loaded
+func newMqttClientOptions(config *util.ConfigStruct, clientID string) (*mqtt.ClientOptions, error) {
 	logger.Debug("Setting MQTT Opts:")
 	// create a new MQTT client
 	opts := mqtt.NewClientOptions()
-	logger.Debug(" OrderMatters: false")
-	opts.SetOrderMatters(false)
+	// messages are passed to handlers in the order they're received, so they're timestamped in order for discarding
+	// location updates that are older than the latest
+	logger.Debug(" OrderMatters: true")
+	opts.SetOrderMatters(true)
 	logger.Debug(" KeepAlive: 30 seconds")
 	opts.SetKeepAlive(30 * time.Second)
 	logger.Debug(" PingTimeout: 10 seconds")
 	opts.SetPingTimeout(10 * time.Second)
 	logger.Debug(" AutoReconnect: true")
 	opts.SetAutoReconnect(true)
-	if util.Config.Global.MqttUser != "" {
+	if config.Global.MqttUser != "" {
 		logger.Debug(" Username: true <redacted value>")
 	} else {
 		logger.Debug(" Username: false (not set)")
 	}
-	opts.SetUsername(util.Config.Global.MqttUser) // if not defined, will just set empty strings and won't be used by pkg
-	if util.Config.Global.MqttPass != "" {
+	opts.SetUsername(config.Global.MqttUser) // if not defined, will just set empty strings and won't be used by pkg
+	if config.Global.MqttPass != "" {
 		logger.Debug(" Password: true <redacted value>")
 	} else {
 		logger.Debug(" Password: false (not set)")
 	}
-	opts.SetPassword(util.Config.Global.MqttPass) // if not defined, will just set empty strings and won't be used by pkg
-	opts.OnConnect = onMqttConnect
-
-	// set conditional MQTT client opts
-	if util.Config.Global.MqttClientID != "" {
-		logger.Debugf(" ClientID: %s", util.Config.Global.MqttClientID)
-		opts.SetClientID(util.Config.Global.MqttClientID)
-	} else {
-		// generate UUID for mqtt client connection if not specified in config file
-		id := uuid.New().String()
-		logger.Debugf(" ClientID: %s", id)
-		opts.SetClientID(id)
-	}
-	logger.Debug(" Protocol: TCP")
-	mqttProtocol := "tcp"
-	if util.Config.Global.MqttUseTls {
+	opts.SetPassword(config.Global.MqttPass) // if not defined, will just set empty strings and won't be used by pkg
+	logger.Debugf(" ClientID: %s", clientID)
+	opts.SetClientID(clientID)
+	logger.Debugf(" ProtocolVersion: %d", config.Global.MqttProtocolVersion)
+	if config.MqttUsesTls() {
 		logger.Debug(" UseTLS: true")
-		logger.Debugf(" SkipTLSVerify: %t", util.Config.Global.MqttSkipTlsVerify)
-		opts.SetTLSConfig(&tls.Config{
-			InsecureSkipVerify: util.Config.Global.MqttSkipTlsVerify,
-		})
-		mqttProtocol = "ssl"
+		logger.Debugf(" SkipTLSVerify: %t", config.Global.MqttSkipTlsVerify)
+		logger.Debugf(" CAFile: %s", valueOrNotSet(config.Global.MqttCaFile))
+		logger.Debugf(" ClientCertFile: %s", valueOrNotSet(config.Global.MqttClientCertFile))
+		logger.Debugf(" TLSServerName: %s", valueOrNotSet(config.Global.MqttTlsServerName))
+		logger.Debugf(" TLSMinVersion: %s", valueOrNotSet(config.Global.MqttTlsMinVersion))
+		tlsConfig, err := config.MqttTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
 	} else {
 		logger.Debug(" UseTLS: false")
 	}
-	broker := fmt.Sprintf("%s://%s:%d", mqttProtocol, util.Config.Global.MqttHost, util.Config.Global.MqttPort)
+	broker := config.MqttBrokerURL()
 	logger.Debugf(" Broker: %s", broker)
 	opts.AddBroker(broker)
 
-	// create a new MQTT client object
-	client := mqtt.NewClient(opts)
+	return opts, nil
+}
 
-	// connect to the MQTT broker
-	logger.Debug("Connecting to MQTT broker")
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		logger.Fatalf("could not connect to mqtt broker: %v", token.Error())
-	} else {
-		logger.Info("Connected to MQTT broker")
+// creates an mqtt client for the configured protocol version; both clients connect, reconnect, and call the OnConnect
+// handler of opts the same way
+func newMqttClient(config *util.ConfigStruct, opts *mqtt.ClientOptions) mqtt.Client {
+	if config.Global.MqttProtocolVersion == util.MqttProtocolV5 {
+		return mqtt5.NewClient(opts, uint32(config.Global.MqttSessionExpiry))
 	}
-	logger.Debugf("MQTT Broker Connected: %t", client.IsConnected())
+	return mqtt.NewClient(opts)
+}
 
-	// listen for incoming messages
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+// returns the value, or a placeholder for debug logs if it's empty
+func valueOrNotSet(value string) string {
+	if value == "" {
+		return "(not set)"
+	}
+	return value
+}
 
-	for {
-		select {
-		case message := <-messageChan:
-			m := strings.Split(message.Topic(), "/")
-
-			// locate car and car's garage door
-			var car *util.Car
-			for _, c := range cars {
-				if fmt.Sprintf("%d", c.ID) == m[2] {
-					car = c
-					break
-				}
-			}
+// stops processing messages and garage door actions, waits up to shutdown_timeout seconds for actions already sent to
+// the opener to finish, then saves state and disconnects; actions still in progress after the timeout are logged as
+// interrupted
+func (a *app) shutdown(client mqtt.Client) {
+	config := a.currentConfig()
+	timeout := time.Duration(config.Global.ShutdownTimeout) * time.Second
+	logger.Infof("Received interrupt signal, shutting down (waiting up to %v for garage door actions in progress)...", timeout)
 
-			// if lat or lng received, check geofence
-			switch m[3] {
-			case "geofence":
-				car.PrevGeofence = car.CurGeofence
-				car.CurGeofence = string(message.Payload())
-				logger.Infof("Received geo for car %d: %v", car.ID, car.CurGeofence)
-				go geo.CheckGeofence(util.Config, car)
-			case "latitude":
-				logger.Debugf("Received lat for car %d: %v", car.ID, string(message.Payload()))
-				lat, _ := strconv.ParseFloat(string(message.Payload()), 64)
-				go func(lat float64) {
-					// send as goroutine so it doesn't block other vehicle updates if channel buffer is full
-					car.LocationUpdate <- util.Point{Lat: lat, Lng: 0}
-				}(lat)
-			case "longitude":
-				logger.Debugf("Received long for car %d: %v", car.ID, string(message.Payload()))
-				lng, _ := strconv.ParseFloat(string(message.Payload()), 64)
-				go func(lng float64) {
-					// send as goroutine so it doesn't block other vehicle updates if channel buffer is full
-					car.LocationUpdate <- util.Point{Lat: 0, Lng: lng}
-				}(lng)
-			}
+	// stop forwarding messages to the main loop, which no longer reads them, so the client's handlers don't block
+	close(a.messageStop)
+	a.stopScheduledCloses()
+	for _, car := range a.currentCars() {
+		if depth := car.LocationUpdate.Depth(); depth > 0 {
+			logger.Infof("Discarding %d location update(s) for car %d", depth, car.ID)
+		}
+		car.LocationUpdate.Close() // stops the car's processLocationUpdates goroutine
+	}
 
-		case <-signalChannel:
-			logger.Info("Received interrupt signal, shutting down...")
-			client.Disconnect(250)
-			time.Sleep(250 * time.Millisecond)
-			return
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if a.apiServer != nil {
+		if err := a.apiServer.Shutdown(ctx); err != nil {
+			logger.Warnf("Unable to shut down http server: %v", err)
+		}
+	}
+	for _, action := range geo.Shutdown(ctx) {
+		trigger := "manual request"
+		if action.CarID != 0 {
+			trigger = fmt.Sprintf("car %d", action.CarID)
+		}
+		logger.Warnf("Interrupted %s action on garage door %s triggered by %s %v ago, the door may not have finished moving", action.Action, action.Door, trigger, time.Since(action.Started).Round(time.Second))
+	}
+
+	// the broker doesn't send the will on a clean disconnect, so mark the app unavailable first
+	if token := client.Publish(publisher.AvailabilityTopic(config.Global.MqttTopicPrefix), 1, true, publisher.AvailabilityOffline); !token.WaitTimeout(time.Second) || token.Error() != nil {
+		logger.Warn("Unable to publish offline availability")
+	}
+	client.Disconnect(250)
 
+	if a.historyDB != nil {
+		a.historyDB.Close()
+	}
+	if a.stateStore != nil {
+		if err := a.stateStore.Close(); err != nil {
+			logger.Warnf("Unable to save state: %v", err)
 		}
 	}
+	logger.Info("Shutdown complete")
 }
 
-// watches the LocationUpdate channel for a car and queues a CheckGeofence operation
+// watches the LocationUpdate mailbox for a car and runs a CheckGeofence operation with the latest location
 // this allows threaded geofence checks for multiple vehicles, while each individual vehicle
-// does not have parallel threads executing checks
-func processLocationUpdates(car *util.Car) {
-	for update := range car.LocationUpdate {
+// does not have parallel threads executing checks; updates received during a check are coalesced
+// so the next check uses the latest fix, and it returns when the mailbox is closed
+func (a *app) processLocationUpdates(car *util.Car) {
+	for {
+		update, ok := car.LocationUpdate.Receive()
+		if !ok {
+			return
+		}
+		car.Lock()
 		if update.Lat != 0 {
 			car.CurrentLocation.Lat = update.Lat
 		}
 		if update.Lng != 0 {
 			car.CurrentLocation.Lng = update.Lng
 		}
-		if car.CurrentLocation.IsPointDefined() {
-			geo.CheckGeofence(util.Config, car)
+		defined := car.CurrentLocation.IsPointDefined()
+		car.Unlock()
+		if defined {
+			a.controller.CheckGeofence(*a.currentConfig(), car)
 		}
 	}
 }
 
 // subscribe to topics when MQTT client connects (or reconnects)
-func onMqttConnect(client mqtt.Client) {
-	for _, car := range cars {
-		logger.Infof("Subscribing to MQTT topics for car %d", car.ID)
+func (a *app) onMqttConnect(client mqtt.Client) {
+	config := a.currentConfig()
+	a.statePub.PublishAll()
 
-		// define which topics are relevant for each car based on config
-		var topics []string
-		switch car.GarageDoor.GeofenceType {
-		case util.PolygonGeofenceType:
-			topics = []string{"latitude", "longitude"}
-		case util.CircularGeofenceType:
-			topics = []string{"latitude", "longitude"}
-		case util.TeslamateGeofenceType:
-			topics = []string{"geofence"}
+	// a failed subscription doesn't stop the others, so the app keeps working for everything that was subscribed
+	subscribed := true
+	for _, car := range a.currentCars() {
+		logger.Infof("Subscribing to MQTT topics for car %d", car.ID)
+		if err := a.subscribeTopics(client, car.ID, carTopics(config, car)); err != nil {
+			logger.Errorf("%v, health checks will report degraded", err)
+			subscribed = false
 		}
+	}
 
-		// subscribe to topics
-		for _, topic := range topics {
-			topicSubscribed := false
-			// retry topic subscription attempts with 1 sec delay between attempts
-			for retryAttempts := 5; retryAttempts > 0; retryAttempts-- {
-				fullTopic := fmt.Sprintf("teslamate/cars/%d/%s", car.ID, topic)
-				logger.Debugf("Subscribing to topic: %s", fullTopic)
-				if token := client.Subscribe(
-					fullTopic,
-					0,
-					func(client mqtt.Client, message mqtt.Message) {
-						messageChan <- message
-					}); token.Wait() && token.Error() == nil {
-					topicSubscribed = true
-					logger.Debugf("Topic subscribed successfully: %s", fullTopic)
-					break
-				} else {
-					logger.Infof("Failed to subscribe to topic %s for car %d, will make %d more attempts. Error: %v", topic, car.ID, retryAttempts, token.Error())
-				}
-				time.Sleep(5 * time.Second)
+	// subscribe to command topic to pause and resume automation
+	logger.Debugf("Subscribing to topic: %s", commandTopic(config))
+	if token := client.Subscribe(
+		commandTopic(config),
+		1,
+		func(client mqtt.Client, message mqtt.Message) {
+			logger.Debugf("Received command: %s", string(message.Payload()))
+			if err := pause.HandleCommand(message.Payload()); err != nil {
+				logger.Warnf("Unable to handle command from topic %s: %v", message.Topic(), err)
 			}
-			if !topicSubscribed {
-				logger.Fatalf("Unable to subscribe to topics, exiting")
+		}); token.Wait() && token.Error() != nil {
+		logger.Errorf("Unable to subscribe to command topic %s, health checks will report degraded. Error: %v", commandTopic(config), token.Error())
+		subscribed = false
+	}
+
+	// announce entities and subscribe to their command topics if home assistant discovery is enabled
+	if a.haDiscovery != nil {
+		a.haDiscovery.Announce()
+		if err := a.haDiscovery.Subscribe(); err != nil {
+			logger.Errorf("Unable to subscribe to home assistant command topics, health checks will report degraded. Error: %v", err)
+			subscribed = false
+		}
+	}
+
+	a.apiServer.SetSubscribed(subscribed)
+	if subscribed {
+		logger.Info("Topics subscribed, listening for events...")
+	} else {
+		logger.Warn("Some topics couldn't be subscribed, listening for events on the others...")
+	}
+}
+
+// returns the teslamate topics relevant to a car based on its garage door's geofence type
+func carTopics(config *util.ConfigStruct, car *util.Car) []string {
+	var topics []string
+	switch car.Snapshot().GarageDoor.GeofenceType {
+	case util.PolygonGeofenceType:
+		topics = []string{"latitude", "longitude"}
+	case util.CircularGeofenceType:
+		topics = []string{"latitude", "longitude"}
+	case util.TeslamateGeofenceType:
+		topics = []string{"geofence"}
+	}
+	template := config.TeslamateTopics()
+	for i, topic := range topics {
+		topics[i] = template.Topic(car.ID, topic)
+	}
+	return topics
+}
+
+// subscribes to a car's topics, retrying each topic up to 5 times; a topic that can't be subscribed doesn't stop the
+// others, and the error lists every topic that failed
+func (a *app) subscribeTopics(client mqtt.Client, carID int, topics []string) error {
+	qos := byte(a.currentConfig().Global.TeslamateQos)
+	var failed []string
+	for _, topic := range topics {
+		topicSubscribed := false
+		// retry topic subscription attempts with a delay between attempts
+		for retryAttempts := 5; retryAttempts > 0; retryAttempts-- {
+			logger.Debugf("Subscribing to topic: %s", topic)
+			if token := client.Subscribe(
+				topic,
+				qos,
+				func(client mqtt.Client, message mqtt.Message) {
+					select {
+					case a.messageChan <- receivedMessage{Message: message, Received: time.Now()}:
+					case <-a.messageStop: // shutting down
+					}
+				}); token.Wait() && token.Error() == nil {
+				topicSubscribed = true
+				logger.Debugf("Topic subscribed successfully: %s", topic)
+				break
+			} else {
+				logger.Infof("Failed to subscribe to topic %s for car %d, will make %d more attempts. Error: %v", topic, carID, retryAttempts, token.Error())
 			}
+			time.Sleep(subscribeRetryDelay)
+		}
+		if !topicSubscribed {
+			failed = append(failed, topic)
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to subscribe to topic(s) %s for car %d", strings.Join(failed, ", "), carID)
+	}
+	return nil
+}
 
-	logger.Info("Topics subscribed, listening for events...")
+// topic to receive commands, e.g. to pause or resume automation
+func commandTopic(config *util.ConfigStruct) string {
+	return config.Global.MqttTopicPrefix + "/command"
 }
 
 // check for env vars and validate that a myq_email and myq_pass exists
-func checkEnvVars() {
+func checkEnvVars(config *util.ConfigStruct) {
+	applyEnvVars(config)
+	if config.Global.MyQEmail == "" || config.Global.MyQPass == "" {
+		logger.Fatal("  MYQ_EMAIL and MYQ_PASS must be defined in the config file or as env vars")
+	}
+	if config.Global.CacheTokenFile != "" && config.Global.TokenCacheKey == "" {
+		logger.Warnf("No token_cache_key or TOKEN_CACHE_KEY defined, the token cache %s will be stored in plaintext", config.Global.CacheTokenFile)
+	}
+}
+
+// overrides config values with env vars if present
+func applyEnvVars(config *util.ConfigStruct) {
 	logger.Debug("Checking environment variables:")
-	// override config with env vars if present
 	if value, exists := os.LookupEnv("MYQ_EMAIL"); exists {
 		logger.Debug("  MYQ_EMAIL defined, overriding config")
-		util.Config.Global.MyQEmail = value
+		config.Global.MyQEmail = value
 	}
 	if value, exists := os.LookupEnv("MYQ_PASS"); exists {
 		logger.Debug("  MYQ_PASS defined, overriding config")
-		util.Config.Global.MyQPass = value
-	}
-	if util.Config.Global.MyQEmail == "" || util.Config.Global.MyQPass == "" {
-		logger.Fatal("  MYQ_EMAIL and MYQ_PASS must be defined in the config file or as env vars")
+		config.Global.MyQPass = value
 	}
 	if value, exists := os.LookupEnv("MQTT_USER"); exists {
 		logger.Debug("  MQTT_USER defined, overriding config")
-		util.Config.Global.MqttUser = value
+		config.Global.MqttUser = value
 	}
 	if value, exists := os.LookupEnv("MQTT_PASS"); exists {
 		logger.Debug("  MQTT_PASS defined, overriding config")
-		util.Config.Global.MqttPass = value
+		config.Global.MqttPass = value
+	}
+	if value, exists := os.LookupEnv("API_TOKEN"); exists {
+		logger.Debug("  API_TOKEN defined, overriding config")
+		config.Global.ApiToken = value
+	}
+	if value, exists := os.LookupEnv("TOKEN_CACHE_KEY"); exists {
+		logger.Debug("  TOKEN_CACHE_KEY defined, overriding config")
+		config.Global.TokenCacheKey = value
 	}
 	if value, exists := os.LookupEnv("TESTING"); exists {
-		util.Config.Testing, _ = strconv.ParseBool(value)
-		logger.Debugf("  TESTING=%t", util.Config.Testing)
+		config.Testing, _ = strconv.ParseBool(value)
+		logger.Debugf("  TESTING=%t", config.Testing)
 	}
 	if value, exists := os.LookupEnv("DEBUG"); exists {
 		logger.Debugf("  DEBUG=%s", value)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/brchri/tesla-youq/internal/api"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	publisher "github.com/brchri/tesla-youq/internal/publisher"
+	util "github.com/brchri/tesla-youq/internal/util"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+// records subscriptions and fails to subscribe to the topics in fail; other client methods are not used by the tests
+type fakeClient struct {
+	mqtt.Client
+	fail       map[string]bool
+	subscribed []string
+	mutex      sync.Mutex
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fail[topic] {
+		return &fakeToken{err: errors.New("not authorized")}
+	}
+	c.subscribed = append(c.subscribed, topic)
+	return &fakeToken{}
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return &fakeToken{}
+}
+
+func (c *fakeClient) IsConnected() bool {
+	return true
+}
+
+// completed token with an optional error
+type fakeToken struct {
+	err error
+}
+
+func (t *fakeToken) Wait() bool                     { return true }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeToken) Error() error                   { return t.err }
+func (t *fakeToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// returns an app for the garage doors without starting location processing, so tests can drive it directly
+func newTestApp(client mqtt.Client, doors ...*util.GarageDoor) *app {
+	config := &util.ConfigStruct{Testing: true, GarageDoors: doors}
+	config.Global.MqttTopicPrefix = "tesla-youq"
+	config.Global.TeslamateTopic = util.DefaultTeslamateTopic
+	var cars []*util.Car
+	for _, g := range doors {
+		for _, c := range g.Cars {
+			c.GarageDoor = g
+			c.LocationUpdate = util.NewLocationMailbox()
+			cars = append(cars, c)
+		}
+	}
+	a := &app{
+		controller:  geo.NewController(nil),
+		messageChan: make(chan receivedMessage),
+		messageStop: make(chan struct{}),
+	}
+	a.setConfig(config, cars)
+	a.statePub = publisher.New(client, config.Global.MqttTopicPrefix, cars)
+	a.apiServer = api.NewServer(config, cars, a.controller, client)
+	return a
+}
+
+// returns a garage door with a circular geofence for the cars
+func newTestDoor(name string, cars ...*util.Car) *util.GarageDoor {
+	return &util.GarageDoor{
+		Name:             name,
+		GeofenceType:     util.CircularGeofenceType,
+		CircularGeofence: &util.CircularGeofence{Center: util.Point{Lat: 46.19290, Lng: -123.79185}, CloseDistance: 0.013, OpenDistance: 0.04},
+		Cars:             cars,
+	}
+}
+
+func Test_onMqttConnect_ContinuesAfterFailedSubscribe(t *testing.T) {
+	delay := subscribeRetryDelay
+	subscribeRetryDelay = 0
+	defer func() { subscribeRetryDelay = delay }()
+
+	client := &fakeClient{fail: map[string]bool{"teslamate/cars/1/latitude": true}}
+	a := newTestApp(client, newTestDoor("main", &util.Car{ID: 1}), newTestDoor("side", &util.Car{ID: 2}))
+	a.onMqttConnect(client)
+
+	// the failed topic doesn't stop the other topics of the car, the other cars, or the command topic
+	assert.Equal(t, []string{
+		"teslamate/cars/1/longitude",
+		"teslamate/cars/2/latitude",
+		"teslamate/cars/2/longitude",
+		"tesla-youq/command",
+	}, client.subscribed)
+
+	rec := httptest.NewRecorder()
+	a.apiServer.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var report api.HealthReport
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.False(t, report.Mqtt.Subscribed)
+
+	// all subscriptions succeed on the next connect
+	client.fail = nil
+	client.subscribed = nil
+	a.onMqttConnect(client)
+	assert.Len(t, client.subscribed, 5)
+	rec = httptest.NewRecorder()
+	a.apiServer.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	"github.com/google/uuid"
+	logger "github.com/sirupsen/logrus"
+)
+
+// publishes a pause or resume command to the running app through the mqtt command topic, e.g.
+// tesla-youq pause -c config.yml --door main --for 2h --reason guests
+func runPauseCommand(command string, args []string) {
+	c := pause.Command{Command: command}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "location of config file")
+	flags.StringVar(&configFile, "c", "", "location of config file")
+	flags.StringVar(&c.Door, "door", "", "only "+command+" automation for this garage door name")
+	flags.IntVar(&c.Car, "car", 0, "only "+command+" automation triggered by this teslamate car id")
+	if command == pause.CommandPause {
+		flags.StringVar(&c.Duration, "for", "", "how long to pause automation, e.g. 2h or 30m; pauses indefinitely if omitted")
+		flags.StringVar(&c.Reason, "reason", "", "reason for pausing automation, included in logs")
+	}
+	flags.Parse(args)
+
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if configFile == "" {
+		logger.Fatal("Config file must be defined with '-c' or 'CONFIG_FILE' environment variable")
+	}
+	config := loadConfig()
+	applyEnvVars(config) // only the mqtt settings are used, so myq credentials aren't required
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// always use a random client id so the running app's connection isn't replaced
+	opts, err := newMqttClientOptions(config, uuid.New().String())
+	if err != nil {
+		logger.Fatalf("Invalid MQTT TLS config: %v", err)
+	}
+	client := newMqttClient(config, opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		logger.Fatalf("could not connect to mqtt broker: %v", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	if token := client.Publish(commandTopic(config), 1, false, payload); token.Wait() && token.Error() != nil {
+		logger.Fatalf("Unable to publish command to %s: %v", commandTopic(config), token.Error())
+	}
+	logger.Infof("Sent %s command to %s", command, commandTopic(config))
+}
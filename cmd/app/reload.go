@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	metrics "github.com/brchri/tesla-youq/internal/metrics"
+	util "github.com/brchri/tesla-youq/internal/util"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	logger "github.com/sirupsen/logrus"
+)
+
+const configPollInterval = 5 * time.Second // how often to check the config and kml files for changes
+
+var (
+	errReloadDeferred  = errors.New("a garage door is operating")
+	errRestartRequired = errors.New("the global section of the config changed, restart to apply it")
+)
+
+// modification times of the config file and the kml files it references
+type configFiles map[string]time.Time
+
+// identifies a car across reloads; a car id can be on several garage doors, each with its own car and state
+type carKey struct {
+	door string
+	id   int
+}
+
+// records the modification times of the config file and the kml files referenced by config
+func statConfigFiles(config util.ConfigStruct) configFiles {
+	files := configFiles{}
+	for _, path := range append([]string{configFile}, config.KMLFiles()...) {
+		files[path] = modTime(path)
+	}
+	return files
+}
+
+// returns true if any of the files were modified, created, or removed since they were recorded
+func (f configFiles) changed() bool {
+	for path, t := range f {
+		if !modTime(path).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the modification time of a file, or zero if it can't be read
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloads the config, logging why it failed if it did; returns true if the reload was deferred because a garage door
+// is operating, in which case it should be retried
+func (a *app) reloadOrDefer(client mqtt.Client) (deferred bool) {
+	err := a.reloadConfig(client)
+	if errors.Is(err, errReloadDeferred) {
+		logger.Infof("Deferring config reload: %v", err)
+		return true
+	} else if err != nil {
+		logger.Errorf("Unable to reload config, continuing with the current config: %v", err)
+	}
+	return false
+}
+
+// registers metrics for a car and starts processing its location updates
+func (a *app) startCar(car *util.Car) {
+	geo.ResetGeofenceState(car, car.GarageDoor)
+	metrics.RegisterCar(car)
+	// start listening to car update location channels
+	go a.processLocationUpdates(car)
+}
+
+// loads and validates the config file and replaces the running config with it; cars keep their runtime state by
+// garage door name and id, and mqtt topics are resubscribed if cars or geofence types changed; the running config is
+// left untouched if the new config is invalid or its global section changed, which requires a restart, and the reload
+// is deferred while a garage door is operating
+func (a *app) reloadConfig(client mqtt.Client) error {
+	config, cars := a.currentConfig(), a.currentCars()
+	newConfig, err := util.Load(configFile)
+	if err != nil {
+		return err
+	}
+	applyEnvVars(newConfig)
+	newConfig.Testing = config.Testing
+	if !reflect.DeepEqual(newConfig.Global, config.Global) {
+		return errRestartRequired
+	}
+
+	// an operation in progress holds pointers to the current garage door, so swapping it out could allow a second
+	// operation on the same door before the first finishes its cooldown
+	for _, g := range config.GarageDoors {
+		if geo.ActionInFlight(g) {
+			return fmt.Errorf("%w: %s", errReloadDeferred, g.Name)
+		}
+	}
+
+	oldDoors := map[string]*util.GarageDoor{}
+	for _, g := range config.GarageDoors {
+		oldDoors[g.Name] = g
+	}
+	oldCars := map[carKey]*util.Car{}
+	oldTopics := map[string]bool{}
+	for _, c := range cars {
+		oldCars[carKey{c.Snapshot().GarageDoor.Name, c.ID}] = c
+		for _, topic := range carTopics(config, c) {
+			oldTopics[topic] = true
+		}
+	}
+
+	// carry over cooldowns by garage door name, and keep existing cars so their location channels and state survive
+	var newCars, keptCars, addedCars []*util.Car
+	kept := map[*util.Car]bool{}
+	for _, g := range newConfig.GarageDoors {
+		if old, ok := oldDoors[g.Name]; ok {
+			_, until := geo.GetCooldown(old)
+			geo.ResumeCooldown(g, until)
+		}
+		for i, c := range g.Cars {
+			if old, ok := oldCars[carKey{g.Name, c.ID}]; ok && !kept[old] {
+				kept[old] = true
+				g.Cars[i] = old
+				geo.ResetGeofenceState(old, g)
+				newCars = append(newCars, old)
+				keptCars = append(keptCars, old)
+				continue
+			}
+			c.GarageDoor = g
+			newCars = append(newCars, c)
+			addedCars = append(addedCars, c)
+		}
+	}
+	for _, c := range cars {
+		if !kept[c] {
+			logger.Infof("Car %d was removed from garage door %s", c.ID, c.Snapshot().GarageDoor.Name)
+			metrics.UnregisterCar(c)
+			c.LocationUpdate.Close()
+		}
+	}
+	for _, c := range keptCars {
+		metrics.RegisterCar(c) // the car's garage door may have changed
+	}
+	for _, c := range addedCars {
+		logger.Infof("Car %d was added to garage door %s", c.ID, c.GarageDoor.Name)
+		a.startCar(c)
+	}
+
+	a.setConfig(newConfig, newCars)
+	a.statePub.SetCars(newCars)
+	a.apiServer.SetConfig(newConfig, newCars)
+	if a.haDiscovery != nil {
+		a.haDiscovery.SetConfig(newConfig)
+	}
+	if a.stateStore != nil {
+		a.stateStore.SetTargets(newCars, newConfig.GarageDoors)
+	}
+	a.stopScheduledCloses()
+	a.stopScheduledCloses = a.controller.WatchScheduledCloses(*newConfig)
+
+	// subscribe in the background since retries would block incoming messages; topics are subscribed from scratch if
+	// the client reconnects in the meantime
+	if client.IsConnected() {
+		go a.resubscribe(client, newConfig, newCars, oldTopics)
+	}
+
+	logger.Infof("Config reloaded successfully with %d garage door(s) and %d car(s)", len(newConfig.GarageDoors), len(newCars))
+	return nil
+}
+
+// unsubscribes from topics of removed cars and subscribes to topics of added cars or cars whose geofence type changed,
+// then announces the reloaded garage doors and cars to home assistant if enabled
+func (a *app) resubscribe(client mqtt.Client, config *util.ConfigStruct, cars []*util.Car, oldTopics map[string]bool) {
+	newTopics := map[string]bool{}
+	for _, c := range cars {
+		var added []string
+		for _, topic := range carTopics(config, c) {
+			newTopics[topic] = true
+			if !oldTopics[topic] {
+				added = append(added, topic)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		logger.Infof("Subscribing to MQTT topics for car %d", c.ID)
+		if err := a.subscribeTopics(client, c.ID, added); err != nil {
+			logger.Errorf("%v, health checks will report degraded", err)
+			a.apiServer.SetSubscribed(false)
+		}
+	}
+
+	var removed []string
+	for topic := range oldTopics {
+		if !newTopics[topic] {
+			removed = append(removed, topic)
+		}
+	}
+	if len(removed) > 0 {
+		logger.Debugf("Unsubscribing from topics: %v", removed)
+		if token := client.Unsubscribe(removed...); token.Wait() && token.Error() != nil {
+			logger.Warnf("Unable to unsubscribe from topics %v: %v", removed, token.Error())
+		}
+	}
+
+	if a.haDiscovery != nil {
+		a.haDiscovery.Announce()
+		if err := a.haDiscovery.Subscribe(); err != nil {
+			logger.Errorf("Unable to subscribe to home assistant command topics, health checks will report degraded. Error: %v", err)
+			a.apiServer.SetSubscribed(false)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// car 1 parks in both garage doors
+const sharedCarConfig = `global:
+  mqtt_host: localhost
+  myq_email: myq@example.com
+  myq_pass: super_secret_password
+garage_doors:
+  - name: main
+    myq_serial: serial_1
+    circular_geofence:
+      center:
+        lat: 46.19290
+        lng: -123.79185
+      close_distance: .013
+      open_distance: .04
+    cars:
+      - teslamate_car_id: 1
+  - name: side
+    myq_serial: serial_2
+    circular_geofence:
+      center:
+        lat: 46.19290
+        lng: -123.79185
+      close_distance: .013
+      open_distance: .04
+    cars:
+      - teslamate_car_id: 1
+`
+
+func Test_reloadConfig_SharedCarID(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "config.yml")
+	defer func() { configFile = "" }()
+	assert.NoError(t, os.WriteFile(configFile, []byte(sharedCarConfig), 0600))
+	config, err := util.Load(configFile)
+	assert.NoError(t, err)
+	applyEnvVars(config)
+
+	a := newTestApp(&fakeClient{}, config.GarageDoors...)
+	a.setConfig(config, a.currentCars())
+	a.stopScheduledCloses = func() {}
+	mainCar, sideCar := config.GarageDoors[0].Cars[0], config.GarageDoors[1].Cars[0]
+	mainCar.CurGeofence, sideCar.CurGeofence = "main", "side"
+
+	// each garage door keeps its own car and state
+	assert.NoError(t, a.reloadConfig(&fakeClient{}))
+	doors := a.currentConfig().GarageDoors
+	assert.Same(t, mainCar, doors[0].Cars[0])
+	assert.Same(t, sideCar, doors[1].Cars[0])
+	assert.Equal(t, "main", mainCar.Snapshot().CurGeofence)
+	assert.Same(t, doors[0], mainCar.Snapshot().GarageDoor)
+	assert.Same(t, doors[1], sideCar.Snapshot().GarageDoor)
+}
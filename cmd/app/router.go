@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/brchri/tesla-youq/internal/metrics"
+	util "github.com/brchri/tesla-youq/internal/util"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	logger "github.com/sirupsen/logrus"
+)
+
+// reasons a teslamate message is dropped, used as the reason label of the dropped messages metric
+const (
+	dropUnmatchedTopic = "unmatched_topic" // topic doesn't match the teslamate topic template
+	dropUnknownCar     = "unknown_car"     // no car with the id, e.g. it was removed by a config reload
+	dropUnknownField   = "unknown_field"   // a field the app doesn't use
+	dropInvalidPayload = "invalid_payload" // a coordinate that isn't a number or is out of range
+)
+
+// an mqtt message and the time it was received from the broker, which orders location updates; it's stamped by the
+// subscription handler, which the client calls for one message at a time in the order they arrive
+type receivedMessage struct {
+	mqtt.Message
+	Received time.Time
+}
+
+// routes a teslamate message to every car with its car id, dropping messages that can't be routed or whose payload is
+// invalid rather than feeding them into the car's state
+func (a *app) routeMessage(message receivedMessage) {
+	config := a.currentConfig()
+	carID, field, ok := config.TeslamateTopics().Parse(message.Topic())
+	if !ok {
+		dropMessage(message, dropUnmatchedTopic, nil)
+		return
+	}
+	targets := a.carsWithID(carID)
+	if len(targets) == 0 {
+		dropMessage(message, dropUnknownCar, nil)
+		return
+	}
+	metrics.MqttMessages.WithLabelValues(strconv.Itoa(carID), field).Inc()
+	payload := string(message.Payload())
+
+	switch field {
+	case "geofence":
+		logger.Infof("Received geo for car %d: %v", carID, payload)
+		for _, car := range targets {
+			car.Lock()
+			car.LastUpdate = message.Received
+			car.PrevGeofence = car.CurGeofence
+			car.CurGeofence = payload
+			car.Unlock()
+			go a.controller.CheckGeofence(*config, car)
+		}
+	case "latitude", "longitude":
+		logger.Debugf("Received %s for car %d: %v", field, carID, payload)
+		value, err := util.ParseCoordinate(field, payload)
+		if err != nil {
+			dropMessage(message, dropInvalidPayload, err)
+			return
+		}
+		update := util.Point{Lat: value}
+		if field == "longitude" {
+			update = util.Point{Lng: value}
+		}
+		for _, car := range targets {
+			// coalesces with updates the car's geofence check hasn't picked up yet, so this never blocks
+			if !car.LocationUpdate.Put(update, message.Received) {
+				logger.Debugf("Discarded %s for car %d older than the latest update", field, carID)
+				continue
+			}
+			car.Lock()
+			if message.Received.After(car.LastUpdate) {
+				car.LastUpdate = message.Received
+			}
+			car.Unlock()
+		}
+	default:
+		dropMessage(message, dropUnknownField, nil)
+	}
+}
+
+// counts and logs a dropped message
+func dropMessage(message mqtt.Message, reason string, err error) {
+	metrics.MqttMessagesDropped.WithLabelValues(reason).Inc()
+	fields := logger.Fields{"topic": message.Topic(), "reason": reason}
+	if err != nil {
+		fields["error"] = err
+	}
+	logger.WithFields(fields).Warn("Dropped MQTT message")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metrics "github.com/brchri/tesla-youq/internal/metrics"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// mqtt message with a topic and payload; other message methods are not used by the router
+type fakeMessage struct {
+	topic   string
+	payload string
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 0 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return []byte(m.payload) }
+func (m *fakeMessage) Ack()              {}
+
+var dropReasons = []string{dropUnmatchedTopic, dropUnknownCar, dropUnknownField, dropInvalidPayload}
+
+func Test_routeMessage(t *testing.T) {
+	// car 1 belongs to both garage doors
+	mainCar, sideCar, otherCar := &util.Car{ID: 1}, &util.Car{ID: 1}, &util.Car{ID: 2}
+	a := newTestApp(&fakeClient{}, newTestDoor("main", mainCar), newTestDoor("side", sideCar, otherCar))
+	cars := []*util.Car{mainCar, sideCar, otherCar}
+
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		dropped string      // reason the message is dropped, or empty if it's routed
+		routed  []*util.Car // cars that receive the update
+	}{
+		{"latitude for a car id on two doors", "teslamate/cars/1/latitude", "46.19290", "", []*util.Car{mainCar, sideCar}},
+		{"longitude", "teslamate/cars/2/longitude", "-123.79185", "", []*util.Car{otherCar}},
+		{"unknown car", "teslamate/cars/3/latitude", "46.19290", dropUnknownCar, nil},
+		{"topic outside the template", "teslamate/home/cars/1/latitude", "46.19290", dropUnmatchedTopic, nil},
+		{"car id that isn't a number", "teslamate/cars/one/latitude", "46.19290", dropUnmatchedTopic, nil},
+		{"unused field", "teslamate/cars/1/speed", "50", dropUnknownField, nil},
+		{"payload that isn't a number", "teslamate/cars/1/latitude", "north", dropInvalidPayload, nil},
+		{"latitude out of range", "teslamate/cars/1/latitude", "91", dropInvalidPayload, nil},
+		{"longitude out of range", "teslamate/cars/2/longitude", "-181", dropInvalidPayload, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			droppedBefore := map[string]float64{}
+			for _, reason := range dropReasons {
+				droppedBefore[reason] = testutil.ToFloat64(metrics.MqttMessagesDropped.WithLabelValues(reason))
+			}
+			depthBefore := map[*util.Car]int{}
+			for _, c := range cars {
+				depthBefore[c] = c.LocationUpdate.Depth()
+			}
+
+			received := time.Now()
+			a.routeMessage(receivedMessage{Message: &fakeMessage{topic: tt.topic, payload: tt.payload}, Received: received})
+
+			for _, reason := range dropReasons {
+				want := droppedBefore[reason]
+				if reason == tt.dropped {
+					want++
+				}
+				assert.Equal(t, want, testutil.ToFloat64(metrics.MqttMessagesDropped.WithLabelValues(reason)), reason)
+			}
+			for _, c := range cars {
+				routed := false
+				for _, r := range tt.routed {
+					routed = routed || r == c
+				}
+				if routed {
+					assert.Equal(t, depthBefore[c]+1, c.LocationUpdate.Depth(), "car %d on %s", c.ID, c.GarageDoor.Name)
+					assert.Equal(t, received, c.Snapshot().LastUpdate)
+				} else {
+					assert.Equal(t, depthBefore[c], c.LocationUpdate.Depth(), "car %d on %s", c.ID, c.GarageDoor.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_routeMessage_Geofence(t *testing.T) {
+	mainCar, sideCar := &util.Car{ID: 1}, &util.Car{ID: 1}
+	a := newTestApp(&fakeClient{}, newTestDoor("main", mainCar), newTestDoor("side", sideCar))
+
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/geofence", payload: "home"}, Received: time.Now()})
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/geofence", payload: "not_home"}, Received: time.Now()})
+	for _, c := range []*util.Car{mainCar, sideCar} {
+		state := c.Snapshot()
+		assert.Equal(t, "home", state.PrevGeofence, c.GarageDoor.Name)
+		assert.Equal(t, "not_home", state.CurGeofence, c.GarageDoor.Name)
+	}
+}
+
+func Test_routeMessage_OutOfOrder(t *testing.T) {
+	car := &util.Car{ID: 1}
+	a := newTestApp(&fakeClient{}, newTestDoor("main", car))
+
+	// a latitude received after a newer one is discarded instead of moving the car back
+	received := time.Now()
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/latitude", payload: "46.19290"}, Received: received})
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/latitude", payload: "46.18000"}, Received: received.Add(-time.Second)})
+	_, stale := car.LocationUpdate.Dropped()
+	assert.Equal(t, uint64(1), stale)
+	assert.Equal(t, 1, car.LocationUpdate.Depth())
+	assert.Equal(t, received, car.Snapshot().LastUpdate)
+
+	// messages stamped in the order they were received all reach the mailbox
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/longitude", payload: "-123.79185"}, Received: received})
+	a.routeMessage(receivedMessage{Message: &fakeMessage{topic: "teslamate/cars/1/latitude", payload: "46.19300"}, Received: received.Add(time.Second)})
+	_, stale = car.LocationUpdate.Dropped()
+	assert.Equal(t, uint64(1), stale)
+	update, ok := car.LocationUpdate.Receive()
+	assert.True(t, ok)
+	assert.Equal(t, util.Point{Lat: 46.19300, Lng: -123.79185}, update)
+}
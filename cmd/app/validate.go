@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+// checks the config file and prints any problems found, exiting with a non-zero status if there are errors, e.g.
+// tesla-youq validate -c config.yml
+func runValidateCommand(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "location of config file")
+	flags.StringVar(&configFile, "c", "", "location of config file")
+	flags.Parse(args)
+
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if configFile == "" {
+		logger.Fatal("Config file must be defined with '-c' or 'CONFIG_FILE' environment variable")
+	}
+
+	// hide log output from loading the config so only diagnostics are printed
+	level := logger.GetLevel()
+	logger.SetLevel(logger.ErrorLevel)
+	diagnostics := util.ValidateConfig(configFile)
+	logger.SetLevel(level)
+
+	for _, d := range diagnostics {
+		if d.Line > 0 {
+			fmt.Printf("%s:%d: %s: %s\n", configFile, d.Line, d.Severity, d.Message)
+		} else {
+			fmt.Printf("%s: %s: %s\n", configFile, d.Severity, d.Message)
+		}
+	}
+	if util.HasErrors(diagnostics) {
+		os.Exit(1)
+	}
+	if len(diagnostics) == 0 {
+		fmt.Printf("%s: no problems found\n", configFile)
+	}
+}
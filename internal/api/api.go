@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	metrics "github.com/brchri/tesla-youq/internal/metrics"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	readTimeout  = 10 * time.Second
+	writeTimeout = 60 * time.Second // door state requests wait for the opener, which may need to log in first
+	idleTimeout  = 120 * time.Second
+)
+
+// reports whether the mqtt client is currently connected to the broker
+type MqttStatus interface {
+	IsConnected() bool
+}
+
+// serves http endpoints for metrics, health reporting, and manual control of garage doors
+type Server struct {
+	config        *util.ConfigStruct
+	cars          []*util.Car
+	controller    *geo.Controller
+	mqtt          MqttStatus
+	subscriptions atomic.Bool  // indicates whether all topic subscriptions succeeded on the last mqtt connect
+	httpServer    *http.Server // set once ListenAndServe is called
+	mutex         sync.Mutex   // guards config, cars, and httpServer
+}
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+func NewServer(config *util.ConfigStruct, cars []*util.Car, controller *geo.Controller, mqtt MqttStatus) *Server {
+	return &Server{
+		config:     config,
+		cars:       cars,
+		controller: controller,
+		mqtt:       mqtt,
+	}
+}
+
+// records whether all topic subscriptions succeeded after connecting to the mqtt broker
+func (s *Server) SetSubscribed(subscribed bool) {
+	s.subscriptions.Store(subscribed)
+}
+
+// replaces the config and cars used by the health and control endpoints, e.g. after the config is reloaded
+func (s *Server) SetConfig(config *util.ConfigStruct, cars []*util.Car) {
+	s.mutex.Lock()
+	s.config = config
+	s.cars = cars
+	s.mutex.Unlock()
+}
+
+func (s *Server) currentConfig() *util.ConfigStruct {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.config
+}
+
+func (s *Server) currentCars() []*util.Car {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cars
+}
+
+// returns handler with all http endpoints registered
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	s.registerControlApi(mux)
+	return mux
+}
+
+// serves http endpoints on addr until the server fails or is shut down; returns nil once shut down
+func (s *Server) ListenAndServe(addr string) error {
+	logger.Infof("Serving http endpoints on %s", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	s.mutex.Lock()
+	s.httpServer = server
+	s.mutex.Unlock()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// stops accepting http requests and waits for requests in progress to finish until ctx is done; a no-op if the server
+// was never started
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	server := s.httpServer
+	s.mutex.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// writes v as json with the provided status code
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debugf("Unable to write http response: %v", err)
+	}
+}
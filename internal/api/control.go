@@ -0,0 +1,254 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brchri/myq"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const apiPrefix = "/api/v1/"
+
+type (
+	// garage door as returned by the control api
+	Door struct {
+		Name          string     `json:"name"`
+		MyQSerial     string     `json:"myq_serial"`
+		GeofenceType  string     `json:"geofence_type"`
+		Cars          []int      `json:"cars"`
+		OnCooldown    bool       `json:"on_cooldown"`
+		CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	}
+
+	// state of a garage door as reported by the opener
+	DoorState struct {
+		Name  string `json:"name"`
+		State string `json:"state"`
+	}
+
+	// car location and zone status as returned by the control api
+	CarStatus struct {
+		ID         int        `json:"id"`
+		Door       string     `json:"door"`
+		Location   util.Point `json:"location"`
+		DistanceKm *float64   `json:"distance_km,omitempty"` // only reported for circular geofences
+		LastUpdate *time.Time `json:"last_update,omitempty"`
+		geo.ZoneStatus
+	}
+
+	ActionResponse struct {
+		Door   string `json:"door"`
+		Action string `json:"action"`
+		Status string `json:"status"`
+	}
+
+	ConfirmationResponse struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	ErrorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+// registers control api endpoints on mux, if an api token is configured
+func (s *Server) registerControlApi(mux *http.ServeMux) {
+	if s.currentConfig().Global.ApiToken == "" {
+		logger.Debug("api_token not defined, control api disabled")
+		return
+	}
+	mux.Handle(apiPrefix, s.requireToken(http.HandlerFunc(s.handleControlApi)))
+}
+
+// rejects requests without a valid bearer token; confirming or cancelling a pending close also accepts the nonce sent
+// with its notification
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.currentConfig().Global.ApiToken)) != 1 && !validNonce(r, token) {
+			writeJson(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or missing api token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// returns true if the request confirms or cancels a pending close and token is the nonce of that confirmation
+func validNonce(r *http.Request, token string) bool {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	if r.Method != http.MethodPost || len(path) != 3 || path[0] != "confirmations" || (path[2] != "confirm" && path[2] != "cancel") {
+		return false
+	}
+	return geo.ValidConfirmationNonce(path[1], token)
+}
+
+// routes control api requests:
+//
+//	GET  /api/v1/doors
+//	GET  /api/v1/doors/{name}
+//	GET  /api/v1/doors/{name}/state
+//	POST /api/v1/doors/{name}/open
+//	POST /api/v1/doors/{name}/close
+//	GET  /api/v1/cars
+//	GET  /api/v1/confirmations
+//	POST /api/v1/confirmations/{id}/confirm
+//	POST /api/v1/confirmations/{id}/cancel
+func (s *Server) handleControlApi(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+
+	switch {
+	case len(path) == 1 && path[0] == "doors":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		var doors []Door
+		for _, g := range s.currentConfig().GarageDoors {
+			doors = append(doors, newDoor(g))
+		}
+		writeJson(w, http.StatusOK, doors)
+
+	case len(path) == 1 && path[0] == "cars":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		var cars []CarStatus
+		for _, c := range s.currentCars() {
+			cars = append(cars, newCarStatus(c))
+		}
+		writeJson(w, http.StatusOK, cars)
+
+	case len(path) == 1 && path[0] == "confirmations":
+		if allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, geo.PendingConfirmations())
+		}
+
+	case len(path) == 3 && path[0] == "confirmations" && (path[2] == "confirm" || path[2] == "cancel"):
+		if allowMethod(w, r, http.MethodPost) {
+			s.handleConfirmation(w, path[1], path[2] == "confirm")
+		}
+
+	case len(path) >= 2 && path[0] == "doors":
+		garageDoor := s.findDoor(path[1])
+		if garageDoor == nil {
+			writeJson(w, http.StatusNotFound, ErrorResponse{Error: "garage door not found"})
+			return
+		}
+		switch {
+		case len(path) == 2:
+			if allowMethod(w, r, http.MethodGet) {
+				writeJson(w, http.StatusOK, newDoor(garageDoor))
+			}
+		case len(path) == 3 && path[2] == "state":
+			if allowMethod(w, r, http.MethodGet) {
+				s.handleDoorState(w, garageDoor)
+			}
+		case len(path) == 3 && (path[2] == myq.ActionOpen || path[2] == myq.ActionClose):
+			if allowMethod(w, r, http.MethodPost) {
+				s.handleDoorAction(w, garageDoor, path[2])
+			}
+		default:
+			writeJson(w, http.StatusNotFound, ErrorResponse{Error: "not found"})
+		}
+
+	default:
+		writeJson(w, http.StatusNotFound, ErrorResponse{Error: "not found"})
+	}
+}
+
+// returns the current door state from the opener
+func (s *Server) handleDoorState(w http.ResponseWriter, garageDoor *util.GarageDoor) {
+	state, err := s.controller.GetDoorState(*s.currentConfig(), garageDoor)
+	if err != nil {
+		writeJson(w, http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, DoorState{Name: garageDoor.Name, State: state})
+}
+
+// requests an open or close action through the same cooldown path as geofence triggered actions
+func (s *Server) handleDoorAction(w http.ResponseWriter, garageDoor *util.GarageDoor, action string) {
+	logger.Infof("Received api request to %s garage door %s", action, garageDoor.Name)
+	if !s.controller.RequestAction(*s.currentConfig(), garageDoor, action) {
+		if geo.ShuttingDown() {
+			writeJson(w, http.StatusServiceUnavailable, ActionResponse{Door: garageDoor.Name, Action: action, Status: "shutting_down"})
+			return
+		}
+		writeJson(w, http.StatusConflict, ActionResponse{Door: garageDoor.Name, Action: action, Status: "on_cooldown"})
+		return
+	}
+	writeJson(w, http.StatusAccepted, ActionResponse{Door: garageDoor.Name, Action: action, Status: "accepted"})
+}
+
+// confirms or cancels a geofence triggered close awaiting confirmation
+func (s *Server) handleConfirmation(w http.ResponseWriter, id string, confirm bool) {
+	if err := geo.ResolveConfirmation(id, confirm); err != nil {
+		writeJson(w, http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	status := "cancelled"
+	if confirm {
+		status = "confirmed"
+	}
+	logger.Infof("Confirmation %s %s through the api", id, status)
+	writeJson(w, http.StatusOK, ConfirmationResponse{ID: id, Status: status})
+}
+
+// returns the garage door with the provided name, or nil if not found
+func (s *Server) findDoor(name string) *util.GarageDoor {
+	for _, g := range s.currentConfig().GarageDoors {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// writes a 405 response and returns false if the request doesn't use the provided method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJson(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return false
+	}
+	return true
+}
+
+func newDoor(g *util.GarageDoor) Door {
+	onCooldown, until := geo.GetCooldown(g)
+	d := Door{
+		Name:         g.Name,
+		MyQSerial:    g.MyQSerial,
+		GeofenceType: g.GeofenceType,
+		OnCooldown:   onCooldown,
+	}
+	for _, c := range g.Cars {
+		d.Cars = append(d.Cars, c.ID)
+	}
+	if onCooldown && !until.IsZero() {
+		d.CooldownUntil = &until
+	}
+	return d
+}
+
+func newCarStatus(c *util.Car) CarStatus {
+	state := c.Snapshot()
+	status := CarStatus{
+		ID:         c.ID,
+		Door:       state.GarageDoor.Name,
+		Location:   state.CurrentLocation,
+		ZoneStatus: geo.GetZoneStatus(c),
+	}
+	if state.GarageDoor.GeofenceType == util.CircularGeofenceType && state.CurrentLocation.IsPointDefined() {
+		status.DistanceKm = &state.CurDistance
+	}
+	if !state.LastUpdate.IsZero() {
+		status.LastUpdate = &state.LastUpdate
+	}
+	return status
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func newControlServer() *Server {
+	config := &util.ConfigStruct{Testing: true}
+	config.Global.ApiToken = "secret"
+	config.Global.OpCooldown = 1
+	door := &util.GarageDoor{Name: "main", MyQSerial: "serial", GeofenceType: util.CircularGeofenceType, CircularGeofence: &util.CircularGeofence{}}
+	car := &util.Car{ID: 1, GarageDoor: door}
+	door.Cars = []*util.Car{car}
+	config.GarageDoors = []*util.GarageDoor{door}
+	return NewServer(config, door.Cars, geo.NewMyqController(), mqttStatus(true))
+}
+
+func request(s *Server, method string, path string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	return rec
+}
+
+func Test_ControlApi_Unauthorized(t *testing.T) {
+	s := newControlServer()
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodGet, "/api/v1/doors", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodGet, "/api/v1/doors", "wrong").Code)
+
+	// the token must be sent as a bearer token
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/doors", nil)
+	r.Header.Set("Authorization", "secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func Test_ControlApi_ListDoorsAndCars(t *testing.T) {
+	s := newControlServer()
+
+	rec := request(s, http.MethodGet, "/api/v1/doors", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var doors []Door
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doors))
+	assert.Len(t, doors, 1)
+	assert.Equal(t, "main", doors[0].Name)
+	assert.Equal(t, []int{1}, doors[0].Cars)
+
+	rec = request(s, http.MethodGet, "/api/v1/cars", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var cars []CarStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &cars))
+	assert.Len(t, cars, 1)
+	assert.Equal(t, "unknown", cars[0].Zone)
+
+	assert.Equal(t, http.StatusNotFound, request(s, http.MethodGet, "/api/v1/doors/missing", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(s, http.MethodGet, "/api/v1/doors/main/open", "secret").Code)
+}
+
+func Test_ControlApi_ActionCooldown(t *testing.T) {
+	s := newControlServer()
+
+	assert.Equal(t, http.StatusAccepted, request(s, http.MethodPost, "/api/v1/doors/main/close", "secret").Code)
+	assert.Equal(t, http.StatusConflict, request(s, http.MethodPost, "/api/v1/doors/main/open", "secret").Code)
+}
+
+func Test_ControlApi_Confirmations(t *testing.T) {
+	s := newControlServer()
+
+	rec := request(s, http.MethodGet, "/api/v1/confirmations", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, request(s, http.MethodPost, "/api/v1/confirmations/unknown/confirm", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(s, http.MethodGet, "/api/v1/confirmations/unknown/cancel", "secret").Code)
+	// tokens other than the api token are only accepted as the nonce of a pending confirmation
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodPost, "/api/v1/confirmations/unknown/confirm", "nonce").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodPost, "/api/v1/confirmations/unknown/confirm", "").Code)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	geo "github.com/brchri/tesla-youq/internal/geo"
+)
+
+const (
+	StatusOk       = "ok"
+	StatusDegraded = "degraded"
+	StatusUnknown  = "unknown"
+)
+
+type (
+	// overall health report returned by /healthz and /readyz
+	HealthReport struct {
+		Status string         `json:"status"`
+		Mqtt   MqttCheck      `json:"mqtt"`
+		Opener OpenerCheck    `json:"opener"`
+		Cars   map[string]Car `json:"cars"`
+	}
+
+	MqttCheck struct {
+		Status     string `json:"status"`
+		Connected  bool   `json:"connected"`
+		Subscribed bool   `json:"subscribed"` // all topic subscriptions succeeded on the last connect
+	}
+
+	OpenerCheck struct {
+		Status string `json:"status"`
+		geo.OpenerStatus
+	}
+
+	Car struct {
+		Status     string     `json:"status"`
+		LastUpdate *time.Time `json:"last_update,omitempty"`
+		AgeSeconds float64    `json:"age_seconds,omitempty"`
+	}
+)
+
+// liveness check; only reports degraded if the mqtt connection or its subscriptions are down
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	report := s.healthReport()
+	code := http.StatusOK
+	if report.Mqtt.Status != StatusOk {
+		code = http.StatusServiceUnavailable
+	}
+	writeJson(w, code, report)
+}
+
+// readiness check; reports degraded if any check is degraded
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.healthReport()
+	code := http.StatusOK
+	if report.Status != StatusOk {
+		code = http.StatusServiceUnavailable
+	}
+	writeJson(w, code, report)
+}
+
+// collects the current status of the mqtt connection, opener, and per-car staleness
+func (s *Server) healthReport() HealthReport {
+	report := HealthReport{
+		Status: StatusOk,
+		Cars:   map[string]Car{},
+	}
+
+	report.Mqtt.Connected = s.mqtt != nil && s.mqtt.IsConnected()
+	report.Mqtt.Subscribed = s.subscriptions.Load()
+	report.Mqtt.Status = StatusOk
+	if !report.Mqtt.Connected || !report.Mqtt.Subscribed {
+		report.Mqtt.Status = StatusDegraded
+		report.Status = StatusDegraded
+	}
+
+	// opener is considered degraded if its most recent api call failed; it's unknown until it's first used
+	report.Opener.OpenerStatus = geo.GetOpenerStatus()
+	switch {
+	case report.Opener.LastError != "" && report.Opener.LastErrorTime.After(report.Opener.LastSuccess):
+		report.Opener.Status = StatusDegraded
+		report.Status = StatusDegraded
+	case report.Opener.LastSuccess.IsZero():
+		report.Opener.Status = StatusUnknown
+	default:
+		report.Opener.Status = StatusOk
+	}
+
+	threshold := time.Duration(s.currentConfig().Global.StaleCarThreshold) * time.Minute
+	for _, c := range s.currentCars() {
+		car := Car{Status: StatusUnknown}
+		if lastUpdate := c.Snapshot().LastUpdate; !lastUpdate.IsZero() {
+			car.LastUpdate = &lastUpdate
+			car.AgeSeconds = time.Since(lastUpdate).Seconds()
+			car.Status = StatusOk
+			if threshold > 0 && time.Since(lastUpdate) > threshold {
+				car.Status = StatusDegraded
+				report.Status = StatusDegraded
+			}
+		}
+		report.Cars[strconv.Itoa(c.ID)] = car
+	}
+
+	return report
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type mqttStatus bool
+
+func (m mqttStatus) IsConnected() bool {
+	return bool(m)
+}
+
+func Test_Healthz_Disconnected(t *testing.T) {
+	s := NewServer(&util.ConfigStruct{}, nil, nil, mqttStatus(false))
+	s.SetSubscribed(true)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var report HealthReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, StatusDegraded, report.Mqtt.Status)
+	assert.False(t, report.Mqtt.Connected)
+}
+
+func Test_Readyz_StaleCar(t *testing.T) {
+	config := &util.ConfigStruct{}
+	config.Global.StaleCarThreshold = 5
+	cars := []*util.Car{
+		{ID: 1, LastUpdate: time.Now()},
+		{ID: 2, LastUpdate: time.Now().Add(-10 * time.Minute)},
+	}
+	s := NewServer(config, cars, nil, mqttStatus(true))
+	s.SetSubscribed(true)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var report HealthReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, StatusOk, report.Cars["1"].Status)
+	assert.Equal(t, StatusDegraded, report.Cars["2"].Status)
+}
+
+func Test_Shutdown(t *testing.T) {
+	s := NewServer(&util.ConfigStruct{}, nil, nil, mqttStatus(true))
+	assert.NoError(t, s.Shutdown(context.Background())) // no-op before the server starts
+
+	stopped := make(chan error)
+	go func() { stopped <- s.ListenAndServe("127.0.0.1:0") }()
+	for i := 0; i < 100; i++ {
+		s.mutex.Lock()
+		started := s.httpServer != nil
+		s.mutex.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, s.Shutdown(context.Background()))
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("http server didn't stop")
+	}
+}
@@ -0,0 +1,180 @@
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const fetchTimeout = 30 * time.Second
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// keeps pauses in sync with the events of a calendar
+type Syncer struct {
+	calendar util.Calendar
+	source   string // pause source identifying pauses managed by this syncer
+	events   []Event
+	mutex    sync.Mutex
+}
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// loads and parses events from a local .ics file or an http(s) url
+func Load(source string) ([]Event, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := httpClient.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch calendar: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unable to fetch calendar: received status %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read calendar: %w", err)
+		}
+		r = f
+	}
+	defer r.Close()
+	return Parse(r)
+}
+
+// starts a goroutine for each calendar that pauses automation while matching events are in progress
+func Watch(calendars []util.Calendar) {
+	for i, c := range calendars {
+		go NewSyncer(c, i).Run()
+	}
+}
+
+// creates a syncer for a calendar; index distinguishes its pauses from those of other calendars
+func NewSyncer(c util.Calendar, index int) *Syncer {
+	return &Syncer{calendar: c, source: fmt.Sprintf("calendar %d", index+1)}
+}
+
+// reloads the calendar on its refresh interval and reconciles pauses whenever an event starts or ends
+func (s *Syncer) Run() {
+	refresh := time.Duration(s.calendar.Refresh) * time.Minute
+	s.Reload()
+	nextReload := time.Now().Add(refresh)
+	for {
+		now := time.Now()
+		if !now.Before(nextReload) {
+			s.Reload()
+			nextReload = now.Add(refresh)
+		}
+		s.Reconcile(now)
+
+		wake := nextReload
+		if next, ok := s.nextChange(now); ok && next.Before(wake) {
+			wake = next
+		}
+		time.Sleep(time.Until(wake))
+	}
+}
+
+// loads the calendar's events, keeping the previously loaded events if it fails
+func (s *Syncer) Reload() {
+	events, err := Load(s.calendar.Source)
+	if err != nil {
+		logger.Warnf("Unable to load %s, keeping %d previously loaded events: %v", s.source, len(s.events), err)
+		return
+	}
+	s.mutex.Lock()
+	s.events = events
+	s.mutex.Unlock()
+	logger.Debugf("Loaded %d events from %s", len(events), s.source)
+}
+
+// sets pauses for events in progress at time now and removes pauses for events that have ended or been removed
+func (s *Syncer) Reconcile(now time.Time) {
+	desired := map[string]pause.Pause{}
+	s.mutex.Lock()
+	for _, e := range s.events {
+		if !e.Active(now) {
+			continue
+		}
+		for _, p := range s.pausesFor(e) {
+			k := key(p)
+			// overlapping events with the same rule keep the pause that lasts longest
+			if existing, ok := desired[k]; !ok || p.Until.After(existing.Until) {
+				desired[k] = p
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, p := range pause.List() {
+		if p.Source != s.source {
+			continue
+		}
+		if d, ok := desired[key(p)]; ok && d.Until.Equal(p.Until) && d.Reason == p.Reason {
+			delete(desired, key(p))
+			continue
+		}
+		pause.Remove(p)
+	}
+	for _, p := range desired {
+		pause.Set(p)
+	}
+}
+
+// returns the pauses for an event according to the calendar's rules
+func (s *Syncer) pausesFor(e Event) []pause.Pause {
+	rules := s.calendar.Rules
+	if len(rules) == 0 {
+		rules = []util.CalendarRule{{}}
+	}
+	var pauses []pause.Pause
+	for _, r := range rules {
+		if r.Match != "" && !strings.Contains(strings.ToLower(e.Summary), strings.ToLower(r.Match)) {
+			continue
+		}
+		p := pause.Pause{Scope: pause.ScopeGlobal, Until: e.End, Reason: "calendar event " + e.Summary, Action: r.Action, Source: s.source}
+		switch {
+		case r.Door != "":
+			p.Scope, p.Target = pause.ScopeDoor, r.Door
+		case r.Car != 0:
+			p.Scope, p.Target = pause.ScopeCar, strconv.Itoa(r.Car)
+		}
+		pauses = append(pauses, p)
+	}
+	return pauses
+}
+
+// returns the next time after now that an event starts or ends
+func (s *Syncer) nextChange(now time.Time) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var next time.Time
+	for _, e := range s.events {
+		for _, t := range []time.Time{e.Start, e.End} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func key(p pause.Pause) string {
+	return p.Scope + "/" + p.Target + "/" + p.Action
+}
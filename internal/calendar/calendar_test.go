@@ -0,0 +1,189 @@
+package calendar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:party\r\n" +
+	"SUMMARY:Garden party\\, bring\r\n" +
+	"  chairs\r\n" +
+	"DTSTART:20231007T170000Z\r\n" +
+	"DTEND:20231007T230000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:service\r\n" +
+	"SUMMARY:Car service\r\n" +
+	"DTSTART;TZID=\"America/New_York\":20231009T090000\r\n" +
+	"DURATION:PT2H30M\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday\r\n" +
+	"SUMMARY:Vacation\r\n" +
+	"DTSTART;VALUE=DATE:20231010\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func Test_Parse(t *testing.T) {
+	events, err := Parse(strings.NewReader(testCalendar))
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+
+	assert.Equal(t, "Garden party, bring chairs", events[0].Summary)
+	assert.Equal(t, time.Date(2023, 10, 7, 17, 0, 0, 0, time.UTC), events[0].Start.UTC())
+	assert.Equal(t, 6*time.Hour, events[0].End.Sub(events[0].Start))
+
+	ny, _ := time.LoadLocation("America/New_York")
+	assert.True(t, time.Date(2023, 10, 9, 9, 0, 0, 0, ny).Equal(events[1].Start))
+	assert.Equal(t, 150*time.Minute, events[1].End.Sub(events[1].Start))
+
+	assert.Equal(t, time.Date(2023, 10, 10, 0, 0, 0, 0, time.Local), events[2].Start)
+	assert.Equal(t, time.Date(2023, 10, 11, 0, 0, 0, 0, time.Local), events[2].End)
+}
+
+func Test_Parse_UnknownTimezone(t *testing.T) {
+	events, err := Parse(strings.NewReader("BEGIN:VEVENT\nDTSTART;TZID=Eastern Standard Time:20231009T090000\nEND:VEVENT\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 10, 9, 9, 0, 0, 0, time.Local), events[0].Start) // local time with a warning
+}
+
+func Test_parse_Recurring(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2023, 10, 9, 12, 0, 0, 0, ny) // monday
+	events, err := parse(strings.NewReader("BEGIN:VCALENDAR\n"+
+		// mondays and wednesdays every other week, except the first wednesday, which was moved
+		"BEGIN:VEVENT\nUID:cleaning\nSUMMARY:Cleaning\nDTSTART;TZID=America/New_York:20231002T100000\nDURATION:PT3H\n"+
+		"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=5\nEXDATE;TZID=America/New_York:20231016T100000\nEND:VEVENT\n"+
+		"BEGIN:VEVENT\nUID:cleaning\nSUMMARY:Cleaning\nRECURRENCE-ID;TZID=America/New_York:20231004T100000\n"+
+		"DTSTART;TZID=America/New_York:20231005T100000\nDURATION:PT3H\nEND:VEVENT\n"+
+		// the 31st of each month until the end of the year
+		"BEGIN:VEVENT\nUID:bills\nSUMMARY:Bills\nDTSTART;VALUE=DATE:20230831\nRRULE:FREQ=MONTHLY;UNTIL=20231231\nEND:VEVENT\n"+
+		// unsupported rules are skipped
+		"BEGIN:VEVENT\nUID:meeting\nSUMMARY:Meeting\nDTSTART:20231002T100000Z\nRRULE:FREQ=MONTHLY;BYDAY=1MO\nEND:VEVENT\n"+
+		"END:VCALENDAR\n"), now)
+	assert.NoError(t, err)
+
+	var starts []time.Time
+	for _, e := range events {
+		starts = append(starts, e.Start)
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 10, 18, 10, 0, 0, 0, ny), // 10/02 and 10/04 ended before now, 10/16 is excluded, and COUNT ends the rule after 10/30
+		time.Date(2023, 10, 30, 10, 0, 0, 0, ny),
+		time.Date(2023, 10, 5, 10, 0, 0, 0, ny), // moved occurrence
+		time.Date(2023, 10, 31, 0, 0, 0, 0, time.Local),
+		time.Date(2023, 12, 31, 0, 0, 0, 0, time.Local),
+	}, starts)
+	assert.Equal(t, 3*time.Hour, events[0].End.Sub(events[0].Start))
+}
+
+func Test_parseRecurrence_Daylight(t *testing.T) {
+	// occurrences keep their local time across daylight saving changes
+	ny, _ := time.LoadLocation("America/New_York")
+	r, err := parseRecurrence("FREQ=DAILY")
+	assert.NoError(t, err)
+	start := time.Date(2023, 11, 4, 9, 0, 0, 0, ny)
+	events, err := r.occurrences(Event{Start: start, End: start.Add(time.Hour)}, nil, start, start.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, 9, events[1].Start.Hour())
+
+	for _, rule := range []string{"FREQ=HOURLY", "FREQ=DAILY;BYDAY=MO", "FREQ=WEEKLY;BYMONTH=1", "FREQ=DAILY;COUNT=2;UNTIL=20240101", "FREQ=DAILY;INTERVAL=0"} {
+		_, err = parseRecurrence(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func Test_Parse_Invalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("BEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\n"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("BEGIN:VEVENT\nDURATION:P1M\nEND:VEVENT\n"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("not a calendar\n"))
+	assert.Error(t, err)
+}
+
+func Test_Load_Url(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendar.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(testCalendar))
+	}))
+	defer server.Close()
+
+	events, err := Load(server.URL + "/calendar.ics")
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+
+	_, err = Load(server.URL + "/missing.ics")
+	assert.Error(t, err)
+}
+
+// returns an ical event in utc
+func icsEvent(summary string, start time.Time, end time.Time) string {
+	return "BEGIN:VEVENT\nSUMMARY:" + summary + "\nDTSTART:" + start.UTC().Format("20060102T150405Z") +
+		"\nDTEND:" + end.UTC().Format("20060102T150405Z") + "\nEND:VEVENT\n"
+}
+
+func Test_Reconcile(t *testing.T) {
+	pause.Restore(nil)
+	defer pause.Restore(nil)
+
+	now := time.Now().Truncate(time.Second)
+	s := NewSyncer(util.Calendar{Rules: []util.CalendarRule{
+		{Match: "party", Door: "main door", Action: "close"},
+		{Match: "service", Car: 1},
+	}}, 0)
+	s.events, _ = Parse(strings.NewReader(
+		icsEvent("Garden Party", now.Add(-time.Hour), now.Add(time.Hour)) +
+			icsEvent("Car service", now.Add(time.Hour), now.Add(2*time.Hour))))
+
+	// during the party, automatic closes are paused for the main door only
+	s.Reconcile(now)
+	p, paused := pause.ActiveFor("main door", 2, "close")
+	assert.True(t, paused)
+	assert.Equal(t, "calendar event Garden Party", p.Reason)
+	assert.True(t, now.Add(time.Hour).Equal(p.Until))
+	_, paused = pause.ActiveFor("main door", 2, "open")
+	assert.False(t, paused)
+	_, paused = pause.ActiveFor("main door", 1, "open")
+	assert.False(t, paused) // car service hasn't started
+
+	// reconciling again doesn't duplicate the pause
+	s.Reconcile(now)
+	assert.Len(t, pause.List(), 1)
+
+	// the event is removed from the calendar, so its pause is removed
+	s.events = s.events[1:]
+	s.Reconcile(now)
+	assert.Len(t, pause.List(), 0)
+
+	// manual pauses aren't touched
+	pause.Set(pause.Pause{Scope: pause.ScopeGlobal})
+	s.Reconcile(now)
+	assert.Len(t, pause.List(), 1)
+}
+
+func Test_nextChange(t *testing.T) {
+	s := NewSyncer(util.Calendar{}, 0)
+	s.events, _ = Parse(strings.NewReader(testCalendar))
+	next, ok := s.nextChange(time.Date(2023, 10, 7, 18, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2023, 10, 7, 23, 0, 0, 0, time.UTC), next.UTC())
+
+	_, ok = s.nextChange(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
@@ -0,0 +1,271 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// event parsed from an ical VEVENT, or an occurrence of a recurring one
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time // exclusive
+}
+
+// VEVENT being parsed, with the properties needed to expand it if it recurs
+type vevent struct {
+	Event
+	rrule        string      // RRULE value; empty if the event doesn't recur
+	exdates      []time.Time // starts of excluded occurrences
+	recurrenceID time.Time   // start of the occurrence this event replaces; zero if it isn't a modified occurrence
+}
+
+// property of an ical component, e.g. DTSTART;TZID=America/New_York:20231001T090000
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// returns true if the event is in progress at time t
+func (e Event) Active(t time.Time) bool {
+	return !t.Before(e.Start) && t.Before(e.End)
+}
+
+// parses VEVENTs from an ical (.ics) calendar; recurring events are expanded into their occurrences over the next
+// year, and skipped with a warning if their RRULE isn't supported
+func Parse(r io.Reader) ([]Event, error) {
+	return parse(r, time.Now())
+}
+
+// parses VEVENTs, expanding recurring events into occurrences that end after now and start within the horizon
+func parse(r io.Reader, now time.Time) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		vevents  []vevent
+		inEvent  bool
+		event    vevent
+		duration time.Duration
+		hasEnd   bool
+		allDay   bool
+	)
+	for n, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			inEvent = true
+			event, duration, hasEnd, allDay = vevent{}, 0, false, false
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			inEvent = false
+			if event.Start.IsZero() {
+				continue // DTSTART is required, skip malformed events
+			}
+			if !hasEnd {
+				// events without an end last for their duration, or a day for all day events
+				switch {
+				case duration != 0:
+					event.End = event.Start.Add(duration)
+				case allDay:
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+			}
+			vevents = append(vevents, event)
+		case !inEvent:
+			continue
+		case p.name == "UID":
+			event.UID = p.value
+		case p.name == "SUMMARY":
+			event.Summary = unescape(p.value)
+		case p.name == "DTSTART":
+			if event.Start, allDay, err = parseTime(p, time.Local); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		case p.name == "DTEND":
+			if event.End, _, err = parseTime(p, time.Local); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			hasEnd = true
+		case p.name == "DURATION":
+			if duration, err = parseDuration(p.value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		case p.name == "RRULE":
+			event.rrule = p.value
+		case p.name == "EXDATE":
+			// may list several dates, and appear more than once
+			for _, v := range strings.Split(p.value, ",") {
+				p.value = v
+				t, _, err := parseTime(p, time.Local)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", n+1, err)
+				}
+				event.exdates = append(event.exdates, t)
+			}
+		case p.name == "RECURRENCE-ID":
+			if event.recurrenceID, _, err = parseTime(p, time.Local); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		}
+	}
+	return expand(vevents, now), nil
+}
+
+// returns the events with recurring events replaced by their occurrences between now and the horizon; occurrences
+// replaced by a modified occurrence with the same UID are left out, since it's listed as its own event
+func expand(vevents []vevent, now time.Time) []Event {
+	modified := map[string][]time.Time{}
+	for _, v := range vevents {
+		if !v.recurrenceID.IsZero() {
+			modified[v.UID] = append(modified[v.UID], v.recurrenceID)
+		}
+	}
+
+	var events []Event
+	for _, v := range vevents {
+		if v.rrule == "" || !v.recurrenceID.IsZero() {
+			events = append(events, v.Event)
+			continue
+		}
+		r, err := parseRecurrence(v.rrule)
+		if err == nil {
+			var occurrences []Event
+			occurrences, err = r.occurrences(v.Event, append(v.exdates, modified[v.UID]...), now, now.Add(recurrenceHorizon))
+			events = append(events, occurrences...)
+		}
+		if err != nil {
+			logger.Warnf("Skipping recurring calendar event %s: %v", v.Summary, err)
+		}
+	}
+	return events
+}
+
+// joins folded lines, which continue on the next line after a leading space or tab
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splits a content line into its name, parameters, and value; parameter values may be quoted and contain colons
+func parseProperty(line string) (property, error) {
+	p := property{params: map[string]string{}}
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ':' && !quoted:
+			p.value = line[i+1:]
+			parts := strings.Split(line[:i], ";")
+			p.name = strings.ToUpper(parts[0])
+			for _, param := range parts[1:] {
+				k, v, _ := strings.Cut(param, "=")
+				p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+			return p, nil
+		}
+	}
+	return p, fmt.Errorf("invalid content line %q", line)
+}
+
+// parses a date-time value such as DTSTART as a date (all day), utc, TZID, or floating time in loc; TZIDs that can't
+// be loaded, such as windows timezone names, use loc with a warning
+func parseTime(p property, loc *time.Location) (t time.Time, allDay bool, err error) {
+	if tzid := p.params["TZID"]; tzid != "" {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			logger.Warnf("Unknown calendar timezone %s in %s, using %s instead", tzid, p.name, loc)
+		} else {
+			loc = l
+		}
+	}
+	switch {
+	case p.params["VALUE"] == "DATE" || len(p.value) == 8:
+		t, err = time.ParseInLocation("20060102", p.value, loc)
+		allDay = true
+	case strings.HasSuffix(p.value, "Z"):
+		t, err = time.Parse("20060102T150405Z", p.value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", p.value, loc)
+	}
+	if err != nil {
+		return t, allDay, fmt.Errorf("invalid %s %s", p.name, p.value)
+	}
+	return t, allDay, nil
+}
+
+// parses an ical duration, e.g. PT1H30M, P1D, or P2W
+func parseDuration(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("invalid DURATION %s", s)
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, invalid
+	}
+
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var d time.Duration
+	num := ""
+	inTime := false
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 'T':
+			inTime = true
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			unit, ok := units[c]
+			// M is minutes only in the time part; months aren't valid in ical durations
+			if !ok || num == "" || (c == 'M' && !inTime) || ((c == 'H' || c == 'S') && !inTime) {
+				return 0, invalid
+			}
+			n, _ := strconv.Atoi(num)
+			d += time.Duration(n) * unit
+			num = ""
+		}
+	}
+	if num != "" {
+		return 0, invalid
+	}
+	return sign * d, nil
+}
+
+// unescapes ical text values
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,164 @@
+package calendar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recurrenceHorizon = 366 * 24 * time.Hour // how far ahead of loading the calendar recurring events are expanded
+	maxOccurrences    = 100000               // occurrences generated per rule before giving up, in case of a runaway rule
+)
+
+// RRULE of a recurring event; only rules that repeat daily, weekly, monthly, or yearly on the start date's day, or
+// weekly on a list of days, are supported
+type recurrence struct {
+	freq      string // DAILY, WEEKLY, MONTHLY, or YEARLY
+	interval  int
+	count     int            // total occurrences including the first; 0 if unlimited
+	until     string         // last possible start as an ical date or date-time; empty if unlimited
+	byDay     []time.Weekday // days of the week weekly rules repeat on; the start's day if empty
+	weekStart time.Weekday   // first day of the week, for weekly rules with an interval
+}
+
+var weekdays = map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+
+// parses an RRULE value, e.g. FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;UNTIL=20240101T000000Z; returns an error for
+// rule parts that aren't supported
+func parseRecurrence(value string) (recurrence, error) {
+	r := recurrence{interval: 1, weekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid INTERVAL %s", v)
+			}
+			r.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid COUNT %s", v)
+			}
+			r.count = n
+		case "UNTIL":
+			r.until = v
+		case "BYDAY":
+			for _, d := range strings.Split(strings.ToUpper(v), ",") {
+				day, ok := weekdays[d]
+				if !ok {
+					return r, fmt.Errorf("unsupported BYDAY %s", v) // e.g. 1MO for the first monday of the month
+				}
+				r.byDay = append(r.byDay, day)
+			}
+		case "WKST":
+			day, ok := weekdays[strings.ToUpper(v)]
+			if !ok {
+				return r, fmt.Errorf("invalid WKST %s", v)
+			}
+			r.weekStart = day
+		default:
+			return r, fmt.Errorf("unsupported rule part %s", k)
+		}
+	}
+	switch r.freq {
+	case "DAILY", "MONTHLY", "YEARLY":
+		if len(r.byDay) > 0 {
+			return r, fmt.Errorf("BYDAY is only supported for FREQ=WEEKLY")
+		}
+	case "WEEKLY":
+	default:
+		return r, fmt.Errorf("unsupported FREQ %s", r.freq)
+	}
+	if r.count > 0 && r.until != "" {
+		return r, fmt.Errorf("COUNT and UNTIL can't both be set")
+	}
+	return r, nil
+}
+
+// returns the occurrences of a recurring event that end after from and start before to, skipping excluded starts
+func (r recurrence) occurrences(e Event, excluded []time.Time, from time.Time, to time.Time) ([]Event, error) {
+	var until time.Time
+	if r.until != "" {
+		t, allDay, err := parseTime(property{name: "UNTIL", params: map[string]string{}, value: r.until}, e.Start.Location())
+		if err != nil {
+			return nil, err
+		}
+		if allDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond) // the whole day is included
+		}
+		until = t
+	}
+
+	duration := e.End.Sub(e.Start)
+	var list []Event
+	generated := 0
+	for i := 0; i < maxOccurrences; i++ {
+		start, ok := r.nth(e.Start, i)
+		if !ok {
+			continue // e.g. the 31st in a month without one, which isn't counted
+		}
+		if !start.Before(to) || (!until.IsZero() && start.After(until)) {
+			break
+		}
+		generated++
+		if r.count > 0 && generated > r.count {
+			break
+		}
+		if start.Add(duration).After(from) && !isExcluded(start, excluded) {
+			o := e
+			o.Start, o.End = start, start.Add(duration)
+			list = append(list, o)
+		}
+	}
+	return list, nil
+}
+
+// returns the start of the candidate occurrence at index i, which is false if it doesn't occur; weekly rules with
+// days and daily rules have a candidate for every day after the start
+func (r recurrence) nth(start time.Time, i int) (time.Time, bool) {
+	switch r.freq {
+	case "DAILY":
+		return start.AddDate(0, 0, i*r.interval), true
+	case "WEEKLY":
+		if len(r.byDay) == 0 {
+			return start.AddDate(0, 0, 7*i*r.interval), true
+		}
+		t := start.AddDate(0, 0, i)
+		weeks := (daysSinceWeekStart(start, r.weekStart) + i) / 7
+		if weeks%r.interval != 0 {
+			return t, false
+		}
+		for _, d := range r.byDay {
+			if t.Weekday() == d {
+				return t, true
+			}
+		}
+		return t, false
+	case "MONTHLY":
+		t := start.AddDate(0, i*r.interval, 0)
+		return t, t.Day() == start.Day()
+	default: // YEARLY
+		t := start.AddDate(i*r.interval, 0, 0)
+		return t, t.Day() == start.Day()
+	}
+}
+
+// returns the days between the first day of t's week and t
+func daysSinceWeekStart(t time.Time, weekStart time.Weekday) int {
+	return (int(t.Weekday()) - int(weekStart) + 7) % 7
+}
+
+func isExcluded(t time.Time, excluded []time.Time) bool {
+	for _, x := range excluded {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TypeEvaluation         = "evaluation"          // result of evaluating a location or geofence update against a garage door's geofence
+	TypeGeofenceTransition = "geofence_transition" // car crossed a geofence boundary that maps to a garage door action
+	TypeActionRequested    = "action_requested"    // garage door action is about to be sent to the opener
+	TypeOpenerResponse     = "opener_response"     // result of sending an action to the opener
+	TypeCooldown           = "cooldown"            // cooldown decision for a garage door, e.g. action suppressed or lock released
+	TypeSuppressed         = "suppressed"          // geofence triggered action was not executed because automation is paused or within quiet hours
+	TypePause              = "pause"               // automation was paused or resumed
+	TypeDoorState          = "door_state"          // door state reported by the opener, e.g. open, closing, or stopped; the state is the event's result
+	TypeAttention          = "attention"           // door settled in an unexpected state and requires attention, per the door's unexpected_state_policy
+	TypeConfirmation       = "confirmation"        // geofence triggered close is awaiting, or was resolved by, a confirmation
+
+	ResultNone       = "none"       // evaluation produced no action
+	ResultSuccess    = "success"    // opener action completed successfully
+	ResultFailure    = "failure"    // opener action failed
+	ResultTimeout    = "timeout"    // opener action timed out waiting for the door to change state
+	ResultSuppressed = "suppressed" // action was not executed, e.g. due to cooldown
+	ResultStarted    = "started"    // cooldown started
+	ResultReleased   = "released"   // cooldown released
+	ResultRequested  = "requested"  // confirmation requested
+	ResultConfirmed  = "confirmed"  // confirmation accepted, action will proceed
+	ResultCancelled  = "cancelled"  // confirmation rejected, action skipped
+)
+
+// describes something the app observed or decided, published to all registered handlers
+type Event struct {
+	Time    time.Time
+	Type    string
+	CarID   int    // 0 if the event isn't specific to a car
+	Door    string // garage door name
+	Action  string // open or close, if applicable
+	Result  string
+	Message string
+	Lat     float64
+	Lng     float64
+	ID      string // identifies the pending confirmation for confirmation events
+}
+
+var (
+	handlers = map[int]func(Event){}
+	nextID   int
+	mutex    sync.RWMutex
+)
+
+// registers a handler to receive all published events and returns a function that removes it;
+// handlers are called synchronously from the publishing goroutine and should not block
+func Subscribe(handler func(Event)) (unsubscribe func()) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	id := nextID
+	nextID++
+	handlers[id] = handler
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(handlers, id)
+	}
+}
+
+// sends an event to all registered handlers, setting its time if not already set
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
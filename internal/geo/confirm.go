@@ -0,0 +1,178 @@
+package geo
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/google/uuid"
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/brchri/myq"
+)
+
+// close awaiting confirmation, as returned by PendingConfirmations
+type PendingConfirmation struct {
+	ID      string    `json:"id"`
+	Door    string    `json:"door"`
+	CarID   int       `json:"car_id"`
+	Expires time.Time `json:"expires"`
+}
+
+type confirmation struct {
+	PendingConfirmation
+	nonce    string    // random token sent to notification sinks instead of the api token; only valid for this confirmation
+	response chan bool // receives true to close, false to cancel
+}
+
+var (
+	confirmations         = map[string]*confirmation{}
+	confirmMutex          sync.Mutex
+	ErrNoSuchConfirmation = errors.New("confirmation not found, expired, or already resolved")
+)
+
+// publishes a confirmation request for a geofence triggered close and blocks until it's confirmed, cancelled,
+// or times out; returns true if the close should proceed
+func awaitCloseConfirmation(garageDoor *util.GarageDoor, car *util.Car) bool {
+	timeout := time.Duration(garageDoor.ConfirmTimeout) * time.Second
+	c := &confirmation{
+		PendingConfirmation: PendingConfirmation{
+			ID:      uuid.New().String(),
+			Door:    garageDoor.Name,
+			CarID:   car.ID,
+			Expires: time.Now().Add(timeout),
+		},
+		nonce:    newNonce(),
+		response: make(chan bool, 1),
+	}
+	confirmMutex.Lock()
+	confirmations[c.ID] = c
+	confirmMutex.Unlock()
+	defer func() {
+		confirmMutex.Lock()
+		delete(confirmations, c.ID)
+		confirmMutex.Unlock()
+	}()
+
+	logger.Infof("Requesting confirmation %s to close garage door %s for car %d", c.ID, garageDoor.Name, car.ID)
+	publishConfirmationEvent(garageDoor, car, c.ID, events.ResultRequested, "")
+
+	select {
+	case proceed := <-c.response:
+		return c.resolved(garageDoor, car, proceed)
+	case <-time.After(timeout):
+		if !c.expire() {
+			return c.resolved(garageDoor, car, <-c.response) // answered just as it timed out
+		}
+		logger.Infof("Confirmation to close garage door %s timed out, applying confirm_default %s", garageDoor.Name, garageDoor.ConfirmDefault)
+		publishConfirmationEvent(garageDoor, car, c.ID, events.ResultTimeout, "applied confirm_default "+garageDoor.ConfirmDefault)
+		return garageDoor.ConfirmDefault == util.ConfirmDefaultClose
+	case <-shutdownChan:
+		if !c.expire() {
+			return c.resolved(garageDoor, car, <-c.response)
+		}
+		logger.Warnf("Shutting down, cancelled confirmation to close garage door %s for car %d", garageDoor.Name, car.ID)
+		publishConfirmationEvent(garageDoor, car, c.ID, events.ResultCancelled, "shutting down")
+		return false
+	}
+}
+
+// removes the confirmation so responses are rejected; returns false if ResolveConfirmation already accepted a response,
+// which is then sent on the response channel
+func (c *confirmation) expire() bool {
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+	if _, ok := confirmations[c.ID]; !ok {
+		return false
+	}
+	delete(confirmations, c.ID)
+	return true
+}
+
+// logs and publishes the response to a confirmation; returns true if the close should proceed
+func (c *confirmation) resolved(garageDoor *util.GarageDoor, car *util.Car, proceed bool) bool {
+	result := events.ResultCancelled
+	if proceed {
+		result = events.ResultConfirmed
+	}
+	logger.Infof("Close of garage door %s %s", garageDoor.Name, result)
+	publishConfirmationEvent(garageDoor, car, c.ID, result, "")
+	return proceed
+}
+
+// confirms or cancels a pending close; returns ErrNoSuchConfirmation if it doesn't exist, timed out, or was already
+// resolved
+func ResolveConfirmation(id string, confirm bool) error {
+	confirmMutex.Lock()
+	c, ok := confirmations[id]
+	delete(confirmations, id)
+	confirmMutex.Unlock()
+	if !ok {
+		return ErrNoSuchConfirmation
+	}
+	c.response <- confirm
+	return nil
+}
+
+// returns the nonce that authorizes confirming or cancelling a pending close through the control api without the api
+// token, e.g. from ntfy buttons; it's only valid until the confirmation is resolved or expires
+func ConfirmationNonce(id string) string {
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+	if c, ok := confirmations[id]; ok {
+		return c.nonce
+	}
+	return ""
+}
+
+// returns true if nonce authorizes resolving the pending confirmation with the provided id
+func ValidConfirmationNonce(id string, nonce string) bool {
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+	c, ok := confirmations[id]
+	return ok && c.nonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(c.nonce)) == 1
+}
+
+// returns a random hex token, or an empty string, which is never accepted, if randomness isn't available
+func newNonce() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logger.Warnf("Unable to generate confirmation nonce, confirmation actions will require the api token: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// returns all closes awaiting confirmation, ordered by expiry
+func PendingConfirmations() []PendingConfirmation {
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+	list := []PendingConfirmation{}
+	for _, c := range confirmations {
+		list = append(list, c.PendingConfirmation)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Expires.Before(list[j].Expires) })
+	return list
+}
+
+func publishConfirmationEvent(garageDoor *util.GarageDoor, car *util.Car, id string, result string, message string) {
+	location := car.Snapshot().CurrentLocation
+	e := events.Event{
+		Type:    events.TypeConfirmation,
+		Door:    garageDoor.Name,
+		CarID:   car.ID,
+		Action:  myq.ActionClose,
+		Result:  result,
+		Message: message,
+		Lat:     location.Lat,
+		Lng:     location.Lng,
+		ID:      id,
+	}
+	events.Publish(e)
+}
@@ -1,13 +1,19 @@
 package geo
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"math"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	events "github.com/brchri/tesla-youq/internal/events"
+	metrics "github.com/brchri/tesla-youq/internal/metrics"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	tokencache "github.com/brchri/tesla-youq/internal/tokencache"
 	util "github.com/brchri/tesla-youq/internal/util"
 	logger "github.com/sirupsen/logrus"
 
@@ -40,29 +46,19 @@ func (m *MyqSessionWrapper) SetPassword(s string) {
 }
 
 func (m *MyqSessionWrapper) DeviceState(s string) (string, error) {
+	defer metrics.ObserveOpenerLatency("device_state", time.Now())
 	return m.myqSession.DeviceState(s)
 }
 
 func (m *MyqSessionWrapper) Login() error {
+	start := time.Now()
 	err := m.myqSession.Login()
-	// cache token if requested
-	if err == nil && util.Config.Global.CacheTokenFile != "" {
-		file, fileErr := os.OpenFile(util.Config.Global.CacheTokenFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-		if fileErr != nil {
-			logger.Infof("WARNING: Unable to write to cache file %s", util.Config.Global.CacheTokenFile)
-		} else {
-			defer file.Close()
-
-			_, writeErr := file.WriteString(m.GetToken())
-			if writeErr != nil {
-				logger.Infof("WARNING: Unable to write to cache file %s", util.Config.Global.CacheTokenFile)
-			}
-		}
-	}
+	metrics.ObserveOpenerLatency("login", start)
 	return err
 }
 
 func (m *MyqSessionWrapper) SetDoorState(serialNumber, action string) error {
+	defer metrics.ObserveOpenerLatency("set_door_state", time.Now())
 	return m.myqSession.SetDoorState(serialNumber, action)
 }
 
@@ -78,11 +74,96 @@ func (m *MyqSessionWrapper) SetToken(token string) {
 	m.myqSession.SetToken(token)
 }
 
-var myqExec MyqSessionInterface // executes myq package commands
+const tokenCacheName = "myq" // name of the myq session token in the token cache
+
+// intermediate and failure door states reported by myq that aren't exported by the myq package
+const (
+	StateOpening    = "opening"
+	StateClosing    = "closing"
+	StateObstructed = "obstructed"
+)
+
+// returned when a door doesn't reach the requested state before its transition timeout
+type TransitionTimeoutError struct {
+	DesiredState string
+	State        string // last observed state
+}
+
+func (e *TransitionTimeoutError) Error() string {
+	return fmt.Sprintf("timed out waiting for door to be %s, last state %s", e.DesiredState, e.State)
+}
+
+// returned when a door settles in a state other than the one requested, e.g. stopped or reversed due to obstruction
+type UnexpectedStateError struct {
+	DesiredState string
+	State        string
+}
+
+func (e *UnexpectedStateError) Error() string {
+	return fmt.Sprintf("door settled in unexpected state %s while waiting for %s", e.State, e.DesiredState)
+}
+
+// reports the most recent results of calls to the myq api, used for health reporting
+type OpenerStatus struct {
+	Authenticated bool      `json:"authenticated"`
+	LastSuccess   time.Time `json:"last_success"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+}
+
+var (
+	openerStatus OpenerStatus
+	openerMutex  sync.Mutex
+	opLockMutex  sync.Mutex // guards checking and setting garage door oplocks across threads
+)
+
+// operates garage doors and reads their state through a myq session
+type Controller struct {
+	session      MyqSessionInterface // executes myq package commands
+	sessionMutex sync.Mutex          // prevents concurrent attempts to acquire a myq session
+}
+
+// returns a controller that executes myq commands through session, e.g. a mock for testing
+func NewController(session MyqSessionInterface) *Controller {
+	return &Controller{session: session}
+}
+
+// returns a controller with a new myq session
+func NewMyqController() *Controller {
+	session := &MyqSessionWrapper{}
+	session.New()
+	return NewController(session)
+}
+
+// returns a copy of the current opener status
+func GetOpenerStatus() OpenerStatus {
+	openerMutex.Lock()
+	defer openerMutex.Unlock()
+	return openerStatus
+}
+
+// records the result of a myq api call for health reporting
+func recordOpenerResult(err error) {
+	openerMutex.Lock()
+	defer openerMutex.Unlock()
+	if err != nil {
+		openerStatus.LastError = err.Error()
+		openerStatus.LastErrorTime = time.Now()
+		return
+	}
+	openerStatus.Authenticated = true
+	openerStatus.LastSuccess = time.Now()
+}
+
+// records a failed login so health reporting reflects the opener is no longer authenticated
+func recordOpenerLoginFailure(err error) {
+	recordOpenerResult(err)
+	openerMutex.Lock()
+	defer openerMutex.Unlock()
+	openerStatus.Authenticated = false
+}
 
 func init() {
-	myqExec = &MyqSessionWrapper{}
-	myqExec.New()
 	logger.SetFormatter(&util.CustomFormatter{})
 	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
 		logger.SetLevel(logger.DebugLevel)
@@ -107,11 +188,14 @@ func toRadians(degrees float64) float64 {
 }
 
 // check if outside close geo or inside open geo and set garage door state accordingly
-func CheckGeofence(config util.ConfigStruct, car *util.Car) {
+func (c *Controller) CheckGeofence(config util.ConfigStruct, car *util.Car) {
 
-	// get action based on either geo cross events or distance threshold cross events
+	// get action based on either geo cross events or distance threshold cross events; the car is locked while its
+	// geofence state is updated since checks and config reloads can run concurrently
 	var action string
-	switch car.GarageDoor.GeofenceType {
+	car.Lock()
+	garageDoor := car.GarageDoor
+	switch garageDoor.GeofenceType {
 	case util.TeslamateGeofenceType:
 		action = getGeoChangeEventAction(config, car)
 	case util.CircularGeofenceType:
@@ -119,28 +203,109 @@ func CheckGeofence(config util.ConfigStruct, car *util.Car) {
 	case util.PolygonGeofenceType:
 		action = getPolygonGeoChangeEventAction(config, car)
 	}
+	car.Unlock()
+
+	if action == "" {
+		publishEvent(events.TypeEvaluation, garageDoor, car, "", events.ResultNone, "")
+		return // only execute if there's a valid action to execute
+	}
+	publishEvent(events.TypeEvaluation, garageDoor, car, action, action, "")
+	publishEvent(events.TypeGeofenceTransition, garageDoor, car, action, "", garageDoor.GeofenceType)
+	metrics.GeofenceTransitions.WithLabelValues(strconv.Itoa(car.ID), garageDoor.Name, garageDoor.GeofenceType, action).Inc()
+
+	if suppressed(garageDoor, car, action) {
+		return
+	}
+
+	c.operateGarageDoor(config, garageDoor, car, action)
+}
+
+// returns true and publishes a suppressed event if automatic actions on the garage door are paused or within quiet hours;
+// car may be nil for actions that aren't triggered by a car
+func suppressed(garageDoor *util.GarageDoor, car *util.Car, action string) bool {
+	carID := 0
+	trigger := ""
+	if car != nil {
+		carID = car.ID
+		trigger = fmt.Sprintf(" for car %d", car.ID)
+	}
+	if p, paused := pause.ActiveFor(garageDoor.Name, carID, action); paused {
+		logger.Infof("Automation paused for %s, skipping %s action on garage door %s%s", p, action, garageDoor.Name, trigger)
+		publishEvent(events.TypeSuppressed, garageDoor, car, action, events.ResultSuppressed, "automation paused for "+p.String())
+		return true
+	}
+	if q, quiet := garageDoor.ActiveQuietHours(action, time.Now()); quiet {
+		logger.Infof("Within %s, skipping %s action on garage door %s%s", q, action, garageDoor.Name, trigger)
+		publishEvent(events.TypeSuppressed, garageDoor, car, action, events.ResultSuppressed, "within "+q.String())
+		return true
+	}
+	return false
+}
 
-	if action == "" || car.GarageDoor.OpLock {
-		return // only execute if there's a valid action to execute and the garage door isn't on cooldown
+// requests an action on a garage door outside of a geofence event, e.g. from the http api;
+// the action is subject to the same cooldown as geofence triggered actions and runs in the background;
+// returns false if the garage door is on cooldown and the action was not executed
+func (c *Controller) RequestAction(config util.ConfigStruct, garageDoor *util.GarageDoor, action string) bool {
+	return c.operateGarageDoor(config, garageDoor, nil, action)
+}
+
+// sends action to the garage door in the background if it isn't on cooldown, then holds the cooldown;
+// car is the car that triggered the action, or nil if it was requested manually
+func (c *Controller) operateGarageDoor(config util.ConfigStruct, garageDoor *util.GarageDoor, car *util.Car, action string) bool {
+	opLockMutex.Lock()
+	if shuttingDown {
+		opLockMutex.Unlock()
+		logger.Infof("Shutting down, skipping %s action on garage door %s", action, garageDoor.Name)
+		return false
+	}
+	if garageDoor.OpLock {
+		opLockMutex.Unlock()
+		logger.Debugf("Garage door %s is on cooldown, skipping %s action", garageDoor.Name, action)
+		metrics.CooldownSuppressions.WithLabelValues(garageDoor.Name, action).Inc()
+		publishEvent(events.TypeCooldown, garageDoor, car, action, events.ResultSuppressed, "garage door is on cooldown")
+		return false // only execute if the garage door isn't on cooldown
 	}
+	garageDoor.OpLock = true // set lock so no other threads try to operate the garage before the cooldown period is complete
+	// record the cooldown as soon as the action starts so it's persisted if the app restarts mid-action; it's extended
+	// from when the action finishes below
+	cooldown := time.Duration(config.Global.OpCooldown) * time.Minute
+	garageDoor.CooldownUntil = time.Now().Add(cooldown)
+	startAction(garageDoor, car, action)
+	opLockMutex.Unlock()
 
-	car.GarageDoor.OpLock = true // set lock so no other threads try to operate the garage before the cooldown period is complete
 	// send operation to garage door and wait for timeout to release oplock
 	// run as goroutine to prevent blocking update channels from mqtt broker in main
 	go func() {
-		if car.GarageDoor.GeofenceType == util.TeslamateGeofenceType {
+		switch {
+		case car == nil:
+			logger.Infof("Attempting to %s garage door %s", action, garageDoor.Name)
+		case garageDoor.GeofenceType == util.TeslamateGeofenceType:
 			logger.Infof("Attempting to %s garage door for car %d", action, car.ID)
-		} else {
+		default:
 			// if closing door based on lat and lng, print those values
-			logger.Infof("Attempting to %s garage door for car %d at lat %f, long %f", action, car.ID, car.CurrentLocation.Lat, car.CurrentLocation.Lng)
+			location := car.Snapshot().CurrentLocation
+			logger.Infof("Attempting to %s garage door for car %d at lat %f, long %f", action, car.ID, location.Lat, location.Lng)
+		}
+
+		// geofence triggered closes wait for confirmation if enabled; the oplock is held meanwhile so the door isn't operated
+		// by other triggers, and the cooldown still applies if the close is cancelled to avoid repeated confirmation requests
+		proceed := true
+		if car != nil && action == myq.ActionClose && garageDoor.ConfirmClose {
+			proceed = awaitCloseConfirmation(garageDoor, car)
 		}
 
 		// create retry loop to set the garage door state
-		for i := 1; i > 0; i-- { // temporarily setting to 1 to disable retry logic while myq auth endpoint stabilizes to avoid rate limiting
-			if err := setGarageDoor(config, car.GarageDoor.MyQSerial, action); err == nil {
+		for i := 1; i > 0 && proceed; i-- { // temporarily setting to 1 to disable retry logic while myq auth endpoint stabilizes to avoid rate limiting
+			metrics.DoorActionsAttempted.WithLabelValues(garageDoor.Name, action).Inc()
+			publishEvent(events.TypeActionRequested, garageDoor, car, action, "", "")
+			err := c.setGarageDoor(config, garageDoor, action)
+			publishEvent(events.TypeOpenerResponse, garageDoor, car, action, resultForError(err), errorMessage(err))
+			if err == nil {
 				// no error received, so breaking retry loop
+				metrics.DoorActionsSucceeded.WithLabelValues(garageDoor.Name, action).Inc()
 				break
 			}
+			metrics.DoorActionsFailed.WithLabelValues(garageDoor.Name, action).Inc()
 			if i == 1 {
 				logger.Info("Unable to set garage door state, no further attempts will be made")
 			} else {
@@ -148,9 +313,161 @@ func CheckGeofence(config util.ConfigStruct, car *util.Car) {
 			}
 		}
 
-		time.Sleep(time.Duration(config.Global.OpCooldown) * time.Minute) // keep opLock true for OpCooldown minutes to prevent flapping in case of overlapping geofences
-		car.GarageDoor.OpLock = false                                     // release garage door's operation lock
+		// keep opLock true for OpCooldown minutes to prevent flapping in case of overlapping geofences; the cooldown is
+		// recorded before the action is marked finished so a shutdown waiting on it saves the cooldown
+		finishAction(garageDoor, time.Now().Add(cooldown))
+		publishEvent(events.TypeCooldown, garageDoor, car, action, events.ResultStarted, "")
+		time.Sleep(cooldown)
+		opLockMutex.Lock()
+		garageDoor.OpLock = false // release garage door's operation lock
+		opLockMutex.Unlock()
+		publishEvent(events.TypeCooldown, garageDoor, car, action, events.ResultReleased, "")
 	}()
+	return true
+}
+
+// locks a garage door until the provided time, e.g. to resume a cooldown that was active before a restart
+func ResumeCooldown(garageDoor *util.GarageDoor, until time.Time) {
+	if !until.After(time.Now()) {
+		return
+	}
+	logger.Infof("Resuming cooldown for garage door %s until %s", garageDoor.Name, until.Format("01/02/2006 15:04:05"))
+	opLockMutex.Lock()
+	garageDoor.OpLock = true
+	garageDoor.CooldownUntil = until
+	opLockMutex.Unlock()
+	go func() {
+		time.Sleep(time.Until(until))
+		opLockMutex.Lock()
+		garageDoor.OpLock = false
+		opLockMutex.Unlock()
+		publishEvent(events.TypeCooldown, garageDoor, nil, "", events.ResultReleased, "")
+	}()
+}
+
+// returns whether a garage door is on cooldown and when the cooldown expires; while an action is in progress, until
+// is OpCooldown minutes after the action started and is extended once it finishes
+func GetCooldown(garageDoor *util.GarageDoor) (onCooldown bool, until time.Time) {
+	opLockMutex.Lock()
+	defer opLockMutex.Unlock()
+	return garageDoor.OpLock, garageDoor.CooldownUntil
+}
+
+// publishes an event for a garage door; car is the car the event relates to, or nil if none
+func publishEvent(eventType string, garageDoor *util.GarageDoor, car *util.Car, action string, result string, message string) {
+	e := events.Event{
+		Type:    eventType,
+		Door:    garageDoor.Name,
+		Action:  action,
+		Result:  result,
+		Message: message,
+	}
+	if car != nil {
+		location := car.Snapshot().CurrentLocation
+		e.CarID = car.ID
+		e.Lat = location.Lat
+		e.Lng = location.Lng
+	}
+	events.Publish(e)
+}
+
+// maps an error returned by setGarageDoor to an event result
+func resultForError(err error) string {
+	var timeoutErr *TransitionTimeoutError
+	switch {
+	case err == nil:
+		return events.ResultSuccess
+	case errors.As(err, &timeoutErr):
+		return events.ResultTimeout
+	default:
+		return events.ResultFailure
+	}
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// summarizes which of its garage door's geofences a car is currently in
+type ZoneStatus struct {
+	Zone        string `json:"zone"`                         // summary of the car's location: close, open, away, unknown, or the teslamate geofence name
+	InsideOpen  bool   `json:"inside_open_zone"`             // car is inside the open geofence
+	InsideClose bool   `json:"inside_close_zone"`            // car is inside the close geofence
+	Geofence    string `json:"teslamate_geofence,omitempty"` // current teslamate geofence, if the garage door uses teslamate geofences
+}
+
+const (
+	ZoneClose   = "close"   // inside the close geofence
+	ZoneOpen    = "open"    // inside the open geofence but not the close geofence
+	ZoneAway    = "away"    // outside all geofences
+	ZoneUnknown = "unknown" // no location has been received
+)
+
+// returns the zone status of a car based on its last known location or teslamate geofence
+func GetZoneStatus(car *util.Car) ZoneStatus {
+	state := car.Snapshot()
+	garageDoor := state.GarageDoor
+	var z ZoneStatus
+	switch garageDoor.GeofenceType {
+	case util.TeslamateGeofenceType:
+		z.Geofence = state.CurGeofence
+		z.Zone = state.CurGeofence
+		if z.Zone == "" {
+			z.Zone = ZoneUnknown
+		}
+		return z
+	case util.CircularGeofenceType:
+		if !state.CurrentLocation.IsPointDefined() {
+			z.Zone = ZoneUnknown
+			return z
+		}
+		d := distance(state.CurrentLocation, garageDoor.CircularGeofence.Center)
+		z.InsideOpen = d < garageDoor.CircularGeofence.OpenDistance
+		z.InsideClose = d <= garageDoor.CircularGeofence.CloseDistance
+	case util.PolygonGeofenceType:
+		if !state.CurrentLocation.IsPointDefined() {
+			z.Zone = ZoneUnknown
+			return z
+		}
+		z.InsideOpen = isInsidePolygonGeo(state.CurrentLocation, garageDoor.PolygonGeofence.Open)
+		z.InsideClose = isInsidePolygonGeo(state.CurrentLocation, garageDoor.PolygonGeofence.Close)
+	}
+
+	switch {
+	case z.InsideClose:
+		z.Zone = ZoneClose
+	case z.InsideOpen:
+		z.Zone = ZoneOpen
+	default:
+		z.Zone = ZoneAway
+	}
+	return z
+}
+
+// assigns a car to a garage door and recomputes its geofence state against the garage door's geofences without
+// triggering any actions, e.g. after the config is reloaded with new geofences
+func ResetGeofenceState(car *util.Car, garageDoor *util.GarageDoor) {
+	car.Lock()
+	defer car.Unlock()
+	car.GarageDoor = garageDoor
+	switch car.GarageDoor.GeofenceType {
+	case util.CircularGeofenceType:
+		car.CurDistance = 0
+		if car.CurrentLocation.IsPointDefined() {
+			car.CurDistance = distance(car.CurrentLocation, car.GarageDoor.CircularGeofence.Center)
+		}
+	case util.PolygonGeofenceType:
+		// assume the car is inside both geofences until a location is received, matching startup behavior
+		car.InsidePolyCloseGeo = true
+		car.InsidePolyOpenGeo = true
+		if car.CurrentLocation.IsPointDefined() {
+			car.InsidePolyCloseGeo = isInsidePolygonGeo(car.CurrentLocation, car.GarageDoor.PolygonGeofence.Close)
+			car.InsidePolyOpenGeo = isInsidePolygonGeo(car.CurrentLocation, car.GarageDoor.PolygonGeofence.Open)
+		}
+	}
 }
 
 // gets action based on if there was a relevant distance change
@@ -227,60 +544,87 @@ func isInsidePolygonGeo(p util.Point, geofence []util.Point) bool {
 	return intersections%2 == 1 // are we currently inside a polygon geo
 }
 
-func setGarageDoor(config util.ConfigStruct, deviceSerial string, action string) error {
-	var desiredState string
-	switch action {
-	case myq.ActionOpen:
-		desiredState = myq.StateOpen
-	case myq.ActionClose:
-		desiredState = myq.StateClosed
+// returns the current state of a garage door from myq, acquiring a new session if the cached
+// or current session is no longer valid
+func (c *Controller) GetDoorState(config util.ConfigStruct, garageDoor *util.GarageDoor) (string, error) {
+	c.sessionMutex.Lock()
+	defer c.sessionMutex.Unlock()
+
+	// check for cached token if we haven't retrieved it already
+	if config.Global.CacheTokenFile != "" && c.session.GetToken() == "" {
+		if cache, err := tokencache.Open(config.Global.CacheTokenFile, config.Global.TokenCacheKey, tokenCacheName); err != nil {
+			logger.Warnf("Unable to read token cache: %v", err)
+		} else if token := cache.Get(tokenCacheName); token != "" {
+			c.session.SetToken(token)
+		}
 	}
 
-	if config.Testing {
-		logger.Infof("TESTING flag set - Would attempt action %v", action)
-		return nil
+	curState, err := c.session.DeviceState(garageDoor.MyQSerial)
+	if err == nil {
+		recordOpenerResult(nil)
+		publishEvent(events.TypeDoorState, garageDoor, nil, "", curState, "")
+		return curState, nil
 	}
 
-	// check for cached token if we haven't retrieved it already
-	if util.Config.Global.CacheTokenFile != "" && myqExec.GetToken() == "" {
-		file, err := os.Open(util.Config.Global.CacheTokenFile)
-		if err != nil {
-			logger.Infof("WARNING: Unable to read token cache from %s", util.Config.Global.CacheTokenFile)
-		} else {
-			defer file.Close()
+	// fetching device state may have failed due to invalid session token; try fresh login to resolve
+	logger.Info("Acquiring MyQ session...")
+	c.session.New()
+	c.session.SetUsername(config.Global.MyQEmail)
+	c.session.SetPassword(config.Global.MyQPass)
+	if err := c.session.Login(); err != nil {
+		recordOpenerLoginFailure(err)
+		logger.Infof("ERROR: %v", err)
+		return "", err
+	}
+	logger.Info("Session acquired...")
+	if config.Global.CacheTokenFile != "" {
+		cacheToken(config, c.session.GetToken())
+	}
+	curState, err = c.session.DeviceState(garageDoor.MyQSerial)
+	recordOpenerResult(err)
+	if err != nil {
+		logger.Infof("Couldn't get device state: %v", err)
+		return "", err
+	}
+	publishEvent(events.TypeDoorState, garageDoor, nil, "", curState, "")
+	return curState, nil
+}
 
-			data, err := io.ReadAll(file)
-			if err != nil {
-				logger.Infof("WARNING: Unable to read token cache from %s", util.Config.Global.CacheTokenFile)
-			} else {
-				myqExec.SetToken(string(data))
-			}
-		}
+// writes the myq session token to the token cache so it can be reused after a restart
+func cacheToken(config util.ConfigStruct, token string) {
+	cache, err := tokencache.Open(config.Global.CacheTokenFile, config.Global.TokenCacheKey, tokenCacheName)
+	if errors.Is(err, tokencache.ErrWrongKey) {
+		// the key changed, so replace the cache rather than never caching again
+		logger.Warnf("Unable to read token cache, replacing it: %v", err)
+		cache = tokencache.New(config.Global.CacheTokenFile, config.Global.TokenCacheKey)
+	} else if err != nil {
+		// e.g. the cache is encrypted but the key is missing, which mustn't replace it with a plaintext cache
+		logger.Warnf("Unable to read token cache, not caching the session token: %v", err)
+		return
+	}
+	if err := cache.Set(tokenCacheName, token); err != nil {
+		logger.Warnf("Unable to write token cache %s: %v", config.Global.CacheTokenFile, err)
+	}
+}
+
+func (c *Controller) setGarageDoor(config util.ConfigStruct, garageDoor *util.GarageDoor, action string) error {
+	deviceSerial := garageDoor.MyQSerial
+
+	if config.Testing {
+		logger.Infof("TESTING flag set - Would attempt action %v", action)
+		return nil
 	}
 
-	curState, err := myqExec.DeviceState(deviceSerial)
+	curState, err := c.GetDoorState(config, garageDoor)
 	if err != nil {
-		// fetching device state may have failed due to invalid session token; try fresh login to resolve
-		logger.Info("Acquiring MyQ session...")
-		myqExec.New()
-		myqExec.SetUsername(config.Global.MyQEmail)
-		myqExec.SetPassword(config.Global.MyQPass)
-		if err := myqExec.Login(); err != nil {
-			logger.Infof("ERROR: %v", err)
-			return err
-		}
-		logger.Info("Session acquired...")
-		curState, err = myqExec.DeviceState(deviceSerial)
-		if err != nil {
-			logger.Infof("Couldn't get device state: %v", err)
-			return err
-		}
+		return err
 	}
 
 	logger.Infof("Requested action: %v, Current state: %v", action, curState)
 	if (action == myq.ActionOpen && curState == myq.StateClosed) || (action == myq.ActionClose && curState == myq.StateOpen) {
 		logger.Infof("Attempting action: %v", action)
-		err := myqExec.SetDoorState(deviceSerial, action)
+		err := c.session.SetDoorState(deviceSerial, action)
+		recordOpenerResult(err)
 		if err != nil {
 			logger.Infof("Unable to set door state: %v", err)
 			return err
@@ -290,32 +634,99 @@ func setGarageDoor(config util.ConfigStruct, deviceSerial string, action string)
 		return nil
 	}
 
+	return c.waitForDoorState(garageDoor, action, curState)
+}
+
+// polls the door until it reaches the state requested by action, logging intermediate states along the way;
+// if the door settles in an unexpected state (e.g. reversed due to obstruction), the door's unexpected_state_policy
+// determines whether to retry the action once, notify, or give up
+func (c *Controller) waitForDoorState(garageDoor *util.GarageDoor, action string, startState string) error {
+	desiredState := desiredStateForAction(action)
 	logger.Infof("Waiting for door to %s...", action)
 
+	state, err := c.pollDoorState(garageDoor, desiredState, startState)
+	if err == nil {
+		return nil
+	}
+
+	var unexpectedErr *UnexpectedStateError
+	if !errors.As(err, &unexpectedErr) {
+		return err
+	}
+
+	switch garageDoor.UnexpectedState {
+	case util.UnexpectedStateRetry:
+		logger.Warnf("%v; retrying %s once", err, action)
+		err := c.session.SetDoorState(garageDoor.MyQSerial, action)
+		recordOpenerResult(err)
+		if err != nil {
+			logger.Infof("Unable to set door state: %v", err)
+			return err
+		}
+		_, err = c.pollDoorState(garageDoor, desiredState, state)
+		return err
+	case util.UnexpectedStateNotify:
+		logger.Warnf("Garage door %s requires attention: %v", garageDoor.Name, err)
+		publishEvent(events.TypeAttention, garageDoor, nil, action, events.ResultFailure, err.Error())
+		return err
+	default:
+		logger.Infof("%v; no further attempts will be made", err)
+		return err
+	}
+}
+
+// polls door state every PollInterval seconds until the desired state is reached, the door settles
+// in an unexpected state, or TransitionTimeout seconds elapse; returns the last observed state
+func (c *Controller) pollDoorState(garageDoor *util.GarageDoor, desiredState string, startState string) (string, error) {
 	var currentState string
-	deadline := time.Now().Add(60 * time.Second)
+	moved := false // set once the door has been observed to leave its starting state
+	deadline := time.Now().Add(time.Duration(garageDoor.TransitionTimeout) * time.Second)
 	for time.Now().Before(deadline) {
-		state, err := myqExec.DeviceState(deviceSerial)
+		state, err := c.session.DeviceState(garageDoor.MyQSerial)
+		recordOpenerResult(err)
 		if err != nil {
-			return err
+			return currentState, err
 		}
 		if state != currentState {
 			if currentState != "" {
 				logger.Infof("Door state changed to %s", state)
 			}
 			currentState = state
+			publishEvent(events.TypeDoorState, garageDoor, nil, "", currentState, "")
 		}
 		if currentState == desiredState {
-			break
+			return currentState, nil
 		}
-		time.Sleep(5 * time.Second)
-	}
 
-	if currentState != desiredState {
-		return fmt.Errorf("timed out waiting for door to be %s", desiredState)
+		switch currentState {
+		case StateOpening, StateClosing:
+			moved = true
+		case myq.StateStopped, StateObstructed:
+			return currentState, &UnexpectedStateError{DesiredState: desiredState, State: currentState}
+		case startState:
+			// door reports its starting state again after moving, so it has reversed
+			if moved {
+				return currentState, &UnexpectedStateError{DesiredState: desiredState, State: currentState}
+			}
+		case myq.StateUnknown:
+		default:
+			moved = true
+		}
+		time.Sleep(time.Duration(garageDoor.PollInterval) * time.Second)
 	}
 
-	return nil
+	return currentState, &TransitionTimeoutError{DesiredState: desiredState, State: currentState}
+}
+
+// maps a myq action to the door state expected once the action completes
+func desiredStateForAction(action string) string {
+	switch action {
+	case myq.ActionOpen:
+		return myq.StateOpen
+	case myq.ActionClose:
+		return myq.StateClosed
+	}
+	return ""
 }
 
 func GetGarageDoorSerials(config util.ConfigStruct) error {
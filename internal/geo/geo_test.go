@@ -1,13 +1,18 @@
 package geo
 
 import (
+	"context"
 	"errors"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/brchri/myq"
+	events "github.com/brchri/tesla-youq/internal/events"
 	"github.com/brchri/tesla-youq/internal/mocks"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	tokencache "github.com/brchri/tesla-youq/internal/tokencache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
@@ -15,6 +20,8 @@ import (
 )
 
 var (
+	config util.ConfigStruct // loaded from the example config; tests may construct their own instead
+
 	distanceCar        *util.Car
 	distanceGarageDoor *util.GarageDoor
 
@@ -26,28 +33,32 @@ var (
 )
 
 func init() {
-	util.LoadConfig(filepath.Join("..", "..", "config.example.yml"))
-	util.Config.Global.CacheTokenFile = "" // dont assume cached token in testing
+	c, err := util.Load(filepath.Join("..", "..", "config.example.yml"))
+	if err != nil {
+		panic(err)
+	}
+	config = *c
+	config.Global.CacheTokenFile = "" // dont assume cached token in testing
 
 	// used for testing events based on distance
-	distanceGarageDoor = util.Config.GarageDoors[0]
+	distanceGarageDoor = config.GarageDoors[0]
 	distanceCar = distanceGarageDoor.Cars[0]
 	distanceCar.GarageDoor = distanceGarageDoor
 	distanceCar.GarageDoor.GeofenceType = util.CircularGeofenceType
 
 	// used for testing events based on teslamate geofence changes
-	geofenceGarageDoor = util.Config.GarageDoors[1]
+	geofenceGarageDoor = config.GarageDoors[1]
 	geofenceCar = geofenceGarageDoor.Cars[0]
 	geofenceCar.GarageDoor = geofenceGarageDoor
 	geofenceCar.GarageDoor.GeofenceType = util.TeslamateGeofenceType
 
 	// used for testing events based on teslamate geofence changes
-	polygonGarageDoor = util.Config.GarageDoors[2]
+	polygonGarageDoor = config.GarageDoors[2]
 	polygonCar = polygonGarageDoor.Cars[0]
 	polygonCar.GarageDoor = polygonGarageDoor
 	polygonCar.GarageDoor.GeofenceType = util.PolygonGeofenceType
 
-	util.Config.Global.OpCooldown = 0
+	config.Global.OpCooldown = 0
 }
 
 func Test_getDistanceChangeAction(t *testing.T) {
@@ -55,12 +66,12 @@ func Test_getDistanceChangeAction(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceCar.GarageDoor.CircularGeofence.Center.Lat + 10
 	distanceCar.CurrentLocation.Lng = distanceCar.GarageDoor.CircularGeofence.Center.Lng
 
-	assert.Equal(t, myq.ActionClose, getDistanceChangeAction(util.Config, distanceCar))
+	assert.Equal(t, myq.ActionClose, getDistanceChangeAction(config, distanceCar))
 	assert.Greater(t, distanceCar.CurDistance, distanceCar.GarageDoor.CircularGeofence.CloseDistance)
 
 	distanceCar.CurrentLocation.Lat = distanceCar.GarageDoor.CircularGeofence.Center.Lat
 
-	assert.Equal(t, myq.ActionOpen, getDistanceChangeAction(util.Config, distanceCar))
+	assert.Equal(t, myq.ActionOpen, getDistanceChangeAction(config, distanceCar))
 	assert.Less(t, distanceCar.CurDistance, distanceCar.GarageDoor.CircularGeofence.OpenDistance)
 }
 
@@ -68,12 +79,12 @@ func Test_getGeoChangeEventAction(t *testing.T) {
 	geofenceCar.PrevGeofence = "home"
 	geofenceCar.CurGeofence = "not_home"
 
-	assert.Equal(t, myq.ActionClose, getGeoChangeEventAction(util.Config, geofenceCar))
+	assert.Equal(t, myq.ActionClose, getGeoChangeEventAction(config, geofenceCar))
 
 	geofenceCar.PrevGeofence = "not_home"
 	geofenceCar.CurGeofence = "home"
 
-	assert.Equal(t, myq.ActionOpen, getGeoChangeEventAction(util.Config, geofenceCar))
+	assert.Equal(t, myq.ActionOpen, getGeoChangeEventAction(config, geofenceCar))
 }
 
 func Test_isInsidePolygonGeo(t *testing.T) {
@@ -98,7 +109,7 @@ func Test_getPolygonGeoChangeEventAction(t *testing.T) {
 	polygonCar.CurrentLocation.Lat = 46.19292902096646
 	polygonCar.CurrentLocation.Lng = -123.79984989897177
 
-	assert.Equal(t, myq.ActionClose, getPolygonGeoChangeEventAction(util.Config, polygonCar))
+	assert.Equal(t, myq.ActionClose, getPolygonGeoChangeEventAction(config, polygonCar))
 	assert.Equal(t, false, polygonCar.InsidePolyCloseGeo)
 	assert.Equal(t, true, polygonCar.InsidePolyOpenGeo)
 
@@ -106,14 +117,31 @@ func Test_getPolygonGeoChangeEventAction(t *testing.T) {
 	polygonCar.CurrentLocation.Lat = 46.19243683948096
 	polygonCar.CurrentLocation.Lng = -123.80103692981524
 
-	assert.Equal(t, myq.ActionOpen, getPolygonGeoChangeEventAction(util.Config, polygonCar))
+	assert.Equal(t, myq.ActionOpen, getPolygonGeoChangeEventAction(config, polygonCar))
+}
+
+func Test_ResetGeofenceState(t *testing.T) {
+	// car is outside the close geofence, so reloading the config shouldn't leave it flagged inside and trigger a close
+	polygonCar.InsidePolyCloseGeo = true
+	polygonCar.InsidePolyOpenGeo = false
+	polygonCar.CurrentLocation = util.Point{Lat: 46.19292902096646, Lng: -123.79984989897177}
+	ResetGeofenceState(polygonCar, polygonGarageDoor)
+	assert.False(t, polygonCar.InsidePolyCloseGeo)
+	assert.True(t, polygonCar.InsidePolyOpenGeo)
+	assert.Equal(t, "", getPolygonGeoChangeEventAction(config, polygonCar))
+
+	distanceCar.CurDistance = 0
+	distanceCar.CurrentLocation = util.Point{Lat: distanceGarageDoor.CircularGeofence.Center.Lat + 10, Lng: distanceGarageDoor.CircularGeofence.Center.Lng}
+	ResetGeofenceState(distanceCar, distanceGarageDoor)
+	assert.Greater(t, distanceCar.CurDistance, distanceGarageDoor.CircularGeofence.CloseDistance)
+	assert.Equal(t, "", getDistanceChangeAction(config, distanceCar))
 }
 
 func Test_CheckCircularGeofence_Leaving_NotLoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return("", errors.New("unauthorized")).Once()
@@ -129,14 +157,48 @@ func Test_CheckCircularGeofence_Leaving_NotLoggedIn(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat + 10
 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-	assert.Equal(t, checkGeofenceWrapper(distanceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, distanceCar), true)
+}
+
+func Test_GetDoorState_CachesToken(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+
+	// config is constructed independently of the example config used by other tests
+	cacheFile := filepath.Join(t.TempDir(), "token")
+	c := util.ConfigStruct{}
+	c.Global.CacheTokenFile = cacheFile
+	c.Global.TokenCacheKey = "key"
+	c.Global.MyQEmail = "user@example.com"
+	c.Global.MyQPass = "pass"
+
+	myqSession.EXPECT().GetToken().Return("").Once() // no cached token loaded yet
+	myqSession.EXPECT().DeviceState("serial").Return("", errors.New("unauthorized")).Once()
+	myqSession.EXPECT().New().Once()
+	myqSession.EXPECT().SetUsername("user@example.com").Once()
+	myqSession.EXPECT().SetPassword("pass").Once()
+	myqSession.EXPECT().Login().Return(nil).Once()
+	myqSession.EXPECT().GetToken().Return("token").Once()
+	myqSession.EXPECT().DeviceState("serial").Return(myq.StateClosed, nil).Once()
+
+	state, err := controller.GetDoorState(c, &util.GarageDoor{Name: "test", MyQSerial: "serial"})
+	assert.NoError(t, err)
+	assert.Equal(t, myq.StateClosed, state)
+	data, err := os.ReadFile(cacheFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "token\"")
+	cache, err := tokencache.Open(cacheFile, "key", tokenCacheName)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", cache.Get(tokenCacheName))
 }
 
 func Test_CheckCircularGeofence_Leaving_LoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateOpen, nil).Once()
@@ -147,14 +209,14 @@ func Test_CheckCircularGeofence_Leaving_LoggedIn(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat + 10
 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-	assert.Equal(t, checkGeofenceWrapper(distanceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, distanceCar), true)
 }
 
 func Test_CheckCircularGeofence_Arriving_LoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Arriving home, garage open
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
@@ -165,7 +227,7 @@ func Test_CheckCircularGeofence_Arriving_LoggedIn(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat
 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-	assert.Equal(t, checkGeofenceWrapper(distanceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, distanceCar), true)
 }
 
 // retry logic has been temporarily disabled, so this test is not needed until it's re-enabled
@@ -174,7 +236,7 @@ func Test_CheckCircularGeofence_Arriving_LoggedIn(t *testing.T) {
 // 	myqSession := &mocks.MyqSessionInterface{}
 // 	myqSession.Test(t)
 // 	defer myqSession.AssertExpectations(t)
-// 	myqExec = myqSession
+// 	controller := NewController(myqSession)
 
 // 	// TEST 1 - Arriving home, garage open
 // 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Times(3)
@@ -186,14 +248,14 @@ func Test_CheckCircularGeofence_Arriving_LoggedIn(t *testing.T) {
 // 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat
 // 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-// 	assert.Equal(t, checkGeofenceWrapper(distanceCar), true)
+// 	assert.Equal(t, checkGeofenceWrapper(controller, distanceCar), true)
 // }
 
 func Test_CheckCircularGeofence_LeaveThenArrive_NotLoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return("", errors.New("unauthorized")).Once()
@@ -209,13 +271,10 @@ func Test_CheckCircularGeofence_LeaveThenArrive_NotLoggedIn(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat + 10
 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-	CheckGeofence(util.Config, distanceCar)
+	released := cooldownReleased(distanceGarageDoor)
+	controller.CheckGeofence(config, distanceCar)
 	// wait for oplock to release to ensure goroutine within CheckGeofence function has completed
-	for {
-		if !distanceCar.GarageDoor.OpLock {
-			break
-		}
-	}
+	assert.True(t, released())
 
 	myqSession.AssertExpectations(t) // midpoint check
 
@@ -226,14 +285,14 @@ func Test_CheckCircularGeofence_LeaveThenArrive_NotLoggedIn(t *testing.T) {
 	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat
 	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
 
-	assert.Equal(t, checkGeofenceWrapper(distanceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, distanceCar), true)
 }
 
 func Test_CheckTeslamateGeofence_Leaving_LoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateOpen, nil).Once()
@@ -243,14 +302,14 @@ func Test_CheckTeslamateGeofence_Leaving_LoggedIn(t *testing.T) {
 	geofenceCar.PrevGeofence = "home"
 	geofenceCar.CurGeofence = "not_home"
 
-	assert.Equal(t, checkGeofenceWrapper(geofenceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, geofenceCar), true)
 }
 
 func Test_CheckTeslamateGeofence_Arriving_LoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
@@ -260,14 +319,14 @@ func Test_CheckTeslamateGeofence_Arriving_LoggedIn(t *testing.T) {
 	geofenceCar.PrevGeofence = "not_home"
 	geofenceCar.CurGeofence = "home"
 
-	assert.Equal(t, checkGeofenceWrapper(geofenceCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, geofenceCar), true)
 }
 
 func Test_CheckPolyGeofence_Leaving_NotLoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Leaving home, garage close
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return("", errors.New("unauthorized")).Once()
@@ -284,14 +343,14 @@ func Test_CheckPolyGeofence_Leaving_NotLoggedIn(t *testing.T) {
 	polygonCar.CurrentLocation.Lat = 46.19292902096646
 	polygonCar.CurrentLocation.Lng = -123.79984989897177
 
-	assert.Equal(t, checkGeofenceWrapper(polygonCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, polygonCar), true)
 }
 
 func Test_CheckPolyGeofence_Arriving_LoggedIn(t *testing.T) {
 	myqSession := &mocks.MyqSessionInterface{}
 	myqSession.Test(t)
 	defer myqSession.AssertExpectations(t)
-	myqExec = myqSession
+	controller := NewController(myqSession)
 
 	// TEST 1 - Arriving home, garage open
 	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
@@ -303,19 +362,282 @@ func Test_CheckPolyGeofence_Arriving_LoggedIn(t *testing.T) {
 	polygonCar.CurrentLocation.Lat = 46.19243683948096
 	polygonCar.CurrentLocation.Lng = -123.80103692981524
 
-	assert.Equal(t, checkGeofenceWrapper(polygonCar), true)
+	assert.Equal(t, checkGeofenceWrapper(controller, polygonCar), true)
 }
 
 // runs CheckGeofence and waits for the internal goroutine to complete, signified by the release of oplock,
 // with 100 ms timeout
-func checkGeofenceWrapper(car *util.Car) bool {
-	CheckGeofence(util.Config, car)
-	// wait for oplock to be released with a 100 ms timeout
-	for i := 0; i < 10; i++ {
-		if !car.GarageDoor.OpLock {
-			return true
+func checkGeofenceWrapper(controller *Controller, car *util.Car) bool {
+	released := cooldownReleased(car.GarageDoor)
+	controller.CheckGeofence(config, car)
+	return released()
+}
+
+// returns a function that waits up to 100 ms for the garage door's oplock to be released; the release is observed
+// through its event, which is the action goroutine's last access to the car and door, so tests can modify them after
+func cooldownReleased(garageDoor *util.GarageDoor) func() bool {
+	released := make(chan struct{}, 1)
+	unsubscribe := events.Subscribe(func(e events.Event) {
+		if e.Type == events.TypeCooldown && e.Result == events.ResultReleased && e.Door == garageDoor.Name {
+			select {
+			case released <- struct{}{}:
+			default:
+			}
+		}
+	})
+	return func() bool {
+		defer unsubscribe()
+		select {
+		case <-released:
+			return !onCooldown(garageDoor)
+		case <-time.After(100 * time.Millisecond):
+			return false
 		}
+	}
+}
+
+// returns whether the garage door's oplock is held, which action goroutines write under opLockMutex
+func onCooldown(garageDoor *util.GarageDoor) bool {
+	locked, _ := GetCooldown(garageDoor)
+	return locked
+}
+
+func Test_waitForDoorState_Obstructed_GiveUp(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+
+	garageDoor := *distanceGarageDoor
+	garageDoor.UnexpectedState = util.UnexpectedStateGiveUp
+
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(StateObstructed, nil).Once()
+
+	err := controller.waitForDoorState(&garageDoor, myq.ActionClose, myq.StateOpen)
+	var unexpectedErr *UnexpectedStateError
+	assert.ErrorAs(t, err, &unexpectedErr)
+	assert.Equal(t, StateObstructed, unexpectedErr.State)
+}
+
+func Test_waitForDoorState_Obstructed_Notify(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+
+	garageDoor := *distanceGarageDoor
+	garageDoor.UnexpectedState = util.UnexpectedStateNotify
+	var attention []events.Event
+	unsubscribe := events.Subscribe(func(e events.Event) {
+		if e.Type == events.TypeAttention {
+			attention = append(attention, e)
+		}
+	})
+	defer unsubscribe()
+
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(StateObstructed, nil).Once()
+
+	assert.Error(t, controller.waitForDoorState(&garageDoor, myq.ActionClose, myq.StateOpen))
+	assert.Len(t, attention, 1)
+	assert.Equal(t, garageDoor.Name, attention[0].Door)
+	assert.Equal(t, myq.ActionClose, attention[0].Action)
+}
+
+func Test_waitForDoorState_Stopped_Retry(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+
+	garageDoor := *distanceGarageDoor
+	garageDoor.UnexpectedState = util.UnexpectedStateRetry
+
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateStopped, nil).Once()
+	myqSession.EXPECT().SetDoorState(mock.AnythingOfType("string"), myq.ActionClose).Return(nil).Once()
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
+
+	assert.NoError(t, controller.waitForDoorState(&garageDoor, myq.ActionClose, myq.StateOpen))
+}
+
+func Test_CheckGeofence_Paused(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t) // no myq calls expected while paused
+	controller := NewController(myqSession)
+
+	pause.Set(pause.Pause{Scope: pause.ScopeDoor, Target: distanceGarageDoor.Name})
+	defer pause.Clear(pause.ScopeDoor, distanceGarageDoor.Name)
+
+	distanceCar.CurDistance = 0
+	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat + 10
+	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
+
+	controller.CheckGeofence(config, distanceCar)
+	assert.False(t, onCooldown(distanceGarageDoor))
+}
+
+func Test_awaitCloseConfirmation(t *testing.T) {
+	garageDoor := *distanceGarageDoor
+	garageDoor.ConfirmTimeout = 10
+
+	for _, confirm := range []bool{true, false} {
+		result := make(chan bool)
+		go func() { result <- awaitCloseConfirmation(&garageDoor, distanceCar) }()
+
+		var pending []PendingConfirmation
+		for i := 0; i < 100 && len(pending) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			pending = PendingConfirmations()
+		}
+		assert.Len(t, pending, 1)
+		assert.Equal(t, garageDoor.Name, pending[0].Door)
+		nonce := ConfirmationNonce(pending[0].ID)
+		assert.Len(t, nonce, 64)
+		assert.True(t, ValidConfirmationNonce(pending[0].ID, nonce))
+		assert.False(t, ValidConfirmationNonce(pending[0].ID, ""))
+		assert.False(t, ValidConfirmationNonce("other", nonce))
+		assert.NoError(t, ResolveConfirmation(pending[0].ID, confirm))
+		assert.Equal(t, confirm, <-result)
+		assert.ErrorIs(t, ResolveConfirmation(pending[0].ID, confirm), ErrNoSuchConfirmation)
+		// the nonce can't be used again once the confirmation is resolved
+		assert.False(t, ValidConfirmationNonce(pending[0].ID, nonce))
+		assert.Empty(t, ConfirmationNonce(pending[0].ID))
+	}
+}
+
+func Test_awaitCloseConfirmation_Timeout(t *testing.T) {
+	garageDoor := *distanceGarageDoor
+	garageDoor.ConfirmTimeout = 1
+	garageDoor.ConfirmDefault = util.ConfirmDefaultClose
+
+	result := make(chan bool)
+	go func() { result <- awaitCloseConfirmation(&garageDoor, distanceCar) }()
+	var pending []PendingConfirmation
+	for i := 0; i < 100 && len(pending) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pending = PendingConfirmations()
+	}
+	assert.Len(t, pending, 1)
+	assert.True(t, <-result) // confirm_default applied
+
+	// a response after the timeout isn't reported as confirmed
+	assert.ErrorIs(t, ResolveConfirmation(pending[0].ID, false), ErrNoSuchConfirmation)
+}
+
+func Test_CheckGeofence_QuietHours(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t) // no myq calls expected within quiet hours
+	controller := NewController(myqSession)
+
+	distanceGarageDoor.QuietHours = []util.QuietHours{{Action: myq.ActionClose}}
+	defer func() { distanceGarageDoor.QuietHours = nil }()
+	var suppressed []events.Event
+	unsubscribe := events.Subscribe(func(e events.Event) {
+		if e.Type == events.TypeSuppressed {
+			suppressed = append(suppressed, e)
+		}
+	})
+	defer unsubscribe()
+
+	distanceCar.CurDistance = 0
+	distanceCar.CurrentLocation.Lat = distanceGarageDoor.CircularGeofence.Center.Lat + 10
+	distanceCar.CurrentLocation.Lng = distanceGarageDoor.CircularGeofence.Center.Lng
+
+	controller.CheckGeofence(config, distanceCar)
+	assert.False(t, onCooldown(distanceGarageDoor))
+	assert.Len(t, suppressed, 1)
+	assert.Equal(t, "within quiet hours for close", suppressed[0].Message)
+}
+
+func Test_closeIfOpen(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+
+	// door left open, so it's closed
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateOpen, nil).Twice()
+	myqSession.EXPECT().SetDoorState(mock.AnythingOfType("string"), myq.ActionClose).Return(nil).Once()
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
+
+	released := cooldownReleased(distanceGarageDoor)
+	controller.closeIfOpen(config, distanceGarageDoor)
+	assert.True(t, released())
+
+	// door already closed, so nothing to do
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateClosed, nil).Once()
+	controller.closeIfOpen(config, distanceGarageDoor)
+	assert.False(t, onCooldown(distanceGarageDoor))
+}
+
+func Test_Shutdown(t *testing.T) {
+	myqSession := &mocks.MyqSessionInterface{}
+	myqSession.Test(t)
+	defer myqSession.AssertExpectations(t)
+	controller := NewController(myqSession)
+	defer func() {
+		// shutdown is permanent, so reset it for the other tests
+		opLockMutex.Lock()
+		shuttingDown = false
+		shutdownChan = make(chan struct{})
+		finishedChan = make(chan struct{})
+		opLockMutex.Unlock()
+	}()
+
+	// the door is still closing when shutdown starts
+	release := make(chan struct{})
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).Return(myq.StateOpen, nil).Once()
+	myqSession.EXPECT().SetDoorState(mock.AnythingOfType("string"), myq.ActionClose).Return(nil).Once()
+	myqSession.EXPECT().DeviceState(mock.AnythingOfType("string")).RunAndReturn(func(string) (string, error) {
+		<-release
+		return myq.StateClosed, nil
+	}).Once()
+	garageDoor := *distanceGarageDoor
+	garageDoor.OpLock = false
+	assert.True(t, controller.RequestAction(config, &garageDoor, myq.ActionClose))
+	// the cooldown is recorded as soon as the action starts so it's persisted if the app restarts mid-action
+	onCooldown, until := GetCooldown(&garageDoor)
+	assert.True(t, onCooldown)
+	assert.False(t, until.IsZero())
+	assert.True(t, ActionInFlight(&garageDoor))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	interrupted := Shutdown(ctx)
+	assert.Len(t, interrupted, 1)
+	assert.Equal(t, garageDoor.Name, interrupted[0].Door)
+	assert.Equal(t, myq.ActionClose, interrupted[0].Action)
+	assert.Equal(t, 0, interrupted[0].CarID)
+
+	// new actions are rejected once shutting down
+	otherDoor := *geofenceGarageDoor
+	otherDoor.OpLock = false
+	assert.False(t, controller.RequestAction(config, &otherDoor, myq.ActionOpen))
+	assert.True(t, ShuttingDown())
+
+	// waits for the action to finish
+	close(release)
+	assert.Empty(t, Shutdown(context.Background()))
+}
+
+func Test_awaitCloseConfirmation_Shutdown(t *testing.T) {
+	defer func() {
+		opLockMutex.Lock()
+		shuttingDown = false
+		shutdownChan = make(chan struct{})
+		finishedChan = make(chan struct{})
+		opLockMutex.Unlock()
+	}()
+	garageDoor := *distanceGarageDoor
+	garageDoor.ConfirmTimeout = 10
+	garageDoor.ConfirmDefault = util.ConfirmDefaultClose
+
+	result := make(chan bool)
+	go func() { result <- awaitCloseConfirmation(&garageDoor, distanceCar) }()
+	for i := 0; i < 100 && len(PendingConfirmations()) == 0; i++ {
 		time.Sleep(10 * time.Millisecond)
 	}
-	return false
+	assert.Empty(t, Shutdown(context.Background()))
+	assert.False(t, <-result) // cancelled rather than applying confirm_default
 }
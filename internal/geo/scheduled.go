@@ -0,0 +1,71 @@
+package geo
+
+import (
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/brchri/myq"
+)
+
+// retry interval if close_if_open_at has no upcoming occurrence, e.g. sunset during polar day
+const scheduledCloseRetry = 24 * time.Hour
+
+// starts a goroutine for each garage door with close_if_open_at defined that closes the door if it's open at that time each day;
+// returns a function that stops the goroutines, e.g. before the config is reloaded
+func (c *Controller) WatchScheduledCloses(config util.ConfigStruct) (stop func()) {
+	done := make(chan struct{})
+	for _, g := range config.GarageDoors {
+		if g.CloseIfOpenAt == "" {
+			continue
+		}
+		at, err := util.ParseTimeOfDay(g.CloseIfOpenAt)
+		if err != nil {
+			logger.Warnf("Unable to parse close_if_open_at for garage door %s: %v", g.Name, err)
+			continue
+		}
+		go c.watchScheduledClose(config, g, at, done)
+	}
+	return func() { close(done) }
+}
+
+func (c *Controller) watchScheduledClose(config util.ConfigStruct, garageDoor *util.GarageDoor, at util.TimeOfDay, done <-chan struct{}) {
+	location, _ := garageDoor.Location()
+	for {
+		next, ok := at.Next(time.Now(), location)
+		wait := time.Until(next)
+		if !ok {
+			logger.Infof("No upcoming %s for garage door %s, checking again in %v", garageDoor.CloseIfOpenAt, garageDoor.Name, scheduledCloseRetry)
+			wait = scheduledCloseRetry
+		} else {
+			logger.Debugf("Next check to close garage door %s if open at %s", garageDoor.Name, next.Format("01/02/2006 15:04:05"))
+		}
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return
+		}
+		if ok {
+			c.closeIfOpen(config, garageDoor)
+		}
+	}
+}
+
+// closes the garage door if it's open, unless automation is paused or within quiet hours
+func (c *Controller) closeIfOpen(config util.ConfigStruct, garageDoor *util.GarageDoor) {
+	if suppressed(garageDoor, nil, myq.ActionClose) {
+		return
+	}
+	state, err := c.GetDoorState(config, garageDoor)
+	if err != nil {
+		logger.Warnf("Unable to check if garage door %s was left open: %v", garageDoor.Name, err)
+		return
+	}
+	if state != myq.StateOpen {
+		logger.Debugf("Garage door %s is %s at %s, nothing to do", garageDoor.Name, state, garageDoor.CloseIfOpenAt)
+		return
+	}
+	logger.Infof("Garage door %s was left open at %s, closing", garageDoor.Name, garageDoor.CloseIfOpenAt)
+	c.operateGarageDoor(config, garageDoor, nil, myq.ActionClose)
+}
@@ -0,0 +1,90 @@
+package geo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+)
+
+// garage door action sent to the opener that hasn't reached its requested state yet, as returned by Shutdown
+type InFlightAction struct {
+	Door    string
+	CarID   int // car that triggered the action, or 0 if it was requested manually
+	Action  string
+	Started time.Time
+}
+
+var (
+	inFlight     = map[*util.GarageDoor]InFlightAction{} // guarded by opLockMutex
+	shuttingDown bool                                    // guarded by opLockMutex; set once Shutdown is called
+	shutdownChan = make(chan struct{})                   // closed by Shutdown to cancel pending close confirmations
+	finishedChan = make(chan struct{})                   // closed once shutting down and no actions are in flight
+)
+
+// records an action as in flight; must be called with opLockMutex held
+func startAction(garageDoor *util.GarageDoor, car *util.Car, action string) {
+	a := InFlightAction{Door: garageDoor.Name, Action: action, Started: time.Now()}
+	if car != nil {
+		a.CarID = car.ID
+	}
+	inFlight[garageDoor] = a
+}
+
+// records that an in flight action finished, successfully or not, and that the garage door's cooldown lasts until
+// cooldownUntil
+func finishAction(garageDoor *util.GarageDoor, cooldownUntil time.Time) {
+	opLockMutex.Lock()
+	garageDoor.CooldownUntil = cooldownUntil
+	delete(inFlight, garageDoor)
+	if shuttingDown && len(inFlight) == 0 {
+		close(finishedChan) // no actions start once shutting down, so this is the last one
+	}
+	opLockMutex.Unlock()
+}
+
+// returns true if an action on the garage door is in progress, including one waiting for a close confirmation
+func ActionInFlight(garageDoor *util.GarageDoor) bool {
+	opLockMutex.Lock()
+	defer opLockMutex.Unlock()
+	_, ok := inFlight[garageDoor]
+	return ok
+}
+
+// stops new garage door actions, cancels close confirmations that are still pending, and waits for actions already
+// sent to the opener to reach their requested state until ctx is done; returns the actions still in flight when ctx was
+// done, sorted by garage door name
+func Shutdown(ctx context.Context) []InFlightAction {
+	opLockMutex.Lock()
+	if !shuttingDown {
+		shuttingDown = true
+		close(shutdownChan)
+		if len(inFlight) == 0 {
+			close(finishedChan)
+		}
+	}
+	opLockMutex.Unlock()
+
+	select {
+	case <-finishedChan:
+		return nil
+	case <-ctx.Done():
+	}
+
+	opLockMutex.Lock()
+	defer opLockMutex.Unlock()
+	var interrupted []InFlightAction
+	for _, a := range inFlight {
+		interrupted = append(interrupted, a)
+	}
+	sort.Slice(interrupted, func(i, j int) bool { return interrupted[i].Door < interrupted[j].Door })
+	return interrupted
+}
+
+// returns true once Shutdown has been called and new garage door actions are rejected
+func ShuttingDown() bool {
+	opLockMutex.Lock()
+	defer opLockMutex.Unlock()
+	return shuttingDown
+}
@@ -0,0 +1,204 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // pure go sqlite driver
+)
+
+const (
+	schema = `
+CREATE TABLE IF NOT EXISTS events (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	time    INTEGER NOT NULL,
+	type    TEXT NOT NULL,
+	car_id  INTEGER NOT NULL,
+	door    TEXT NOT NULL,
+	action  TEXT NOT NULL,
+	result  TEXT NOT NULL,
+	message TEXT NOT NULL,
+	lat     REAL NOT NULL,
+	lng     REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE INDEX IF NOT EXISTS events_car_id ON events (car_id, time);
+CREATE INDEX IF NOT EXISTS events_door ON events (door, time);`
+
+	queueSize     = 256       // events buffered for writing before new events are dropped
+	pruneInterval = time.Hour // how often events older than the retention period are deleted
+)
+
+// persists events to an embedded sqlite database
+type DB struct {
+	db          *sql.DB
+	retention   time.Duration
+	queue       chan events.Event
+	unsubscribe func()
+	wg          sync.WaitGroup
+}
+
+// filters events returned by Query; zero values are not applied
+type Filter struct {
+	CarID int
+	Door  string
+	Type  string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// opens or creates the history database at path; events older than retention are pruned
+// while the database is recording, and are kept indefinitely if retention is 0
+func Open(path string, retention time.Duration) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1) // sqlite only supports a single writer
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialize history database %s: %w", path, err)
+	}
+	return &DB{db: db, retention: retention}, nil
+}
+
+// subscribes to published events and writes them to the database in the background
+func (h *DB) Record() {
+	h.queue = make(chan events.Event, queueSize)
+	h.wg.Add(1)
+	go h.writeEvents()
+	h.unsubscribe = events.Subscribe(func(e events.Event) {
+		select {
+		case h.queue <- e:
+		default:
+			logger.Warnf("History queue is full, dropping %s event for car %d", e.Type, e.CarID)
+		}
+	})
+}
+
+// writes queued events and prunes expired events until the queue is closed
+func (h *DB) writeEvents() {
+	defer h.wg.Done()
+	h.prune()
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-h.queue:
+			if !ok {
+				return
+			}
+			if err := h.Insert(e); err != nil {
+				logger.Warnf("Unable to record %s event in history: %v", e.Type, err)
+			}
+		case <-ticker.C:
+			h.prune()
+		}
+	}
+}
+
+// writes a single event to the database
+func (h *DB) Insert(e events.Event) error {
+	_, err := h.db.Exec(
+		`INSERT INTO events (time, type, car_id, door, action, result, message, lat, lng) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixMilli(), e.Type, e.CarID, e.Door, e.Action, e.Result, e.Message, e.Lat, e.Lng,
+	)
+	return err
+}
+
+// deletes events older than the retention period
+func (h *DB) prune() {
+	if h.retention <= 0 {
+		return
+	}
+	result, err := h.db.Exec(`DELETE FROM events WHERE time < ?`, time.Now().Add(-h.retention).UnixMilli())
+	if err != nil {
+		logger.Warnf("Unable to prune history: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Debugf("Pruned %d events from history", n)
+	}
+}
+
+// returns events matching the filter, oldest first
+func (h *DB) Query(f Filter) ([]events.Event, error) {
+	var conditions []string
+	var args []interface{}
+	if f.CarID != 0 {
+		conditions = append(conditions, "car_id = ?")
+		args = append(args, f.CarID)
+	}
+	if f.Door != "" {
+		conditions = append(conditions, "door = ?")
+		args = append(args, f.Door)
+	}
+	if f.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, f.Type)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "time <= ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+
+	const columns = `time, type, car_id, door, action, result, message, lat, lng`
+	query := `SELECT id, ` + columns + ` FROM events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// select the most recent events up to the limit, then return them in chronological order
+	query += " ORDER BY time DESC, id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+	query = `SELECT ` + columns + ` FROM (` + query + `) ORDER BY time ASC, id ASC`
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []events.Event
+	for rows.Next() {
+		var e events.Event
+		var t int64
+		if err := rows.Scan(&t, &e.Type, &e.CarID, &e.Door, &e.Action, &e.Result, &e.Message, &e.Lat, &e.Lng); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(t)
+		results = append(results, e)
+	}
+	return results, rows.Err()
+}
+
+// stops recording, flushing queued events, and closes the database
+func (h *DB) Close() error {
+	if h.queue != nil {
+		h.unsubscribe()
+		close(h.queue)
+		h.wg.Wait()
+	}
+	return h.db.Close()
+}
@@ -0,0 +1,60 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_InsertAndQuery(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "history.db"), 0)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	assert.NoError(t, db.Insert(events.Event{Time: now.Add(-2 * time.Hour), Type: events.TypeEvaluation, CarID: 1, Door: "main", Result: events.ResultNone}))
+	assert.NoError(t, db.Insert(events.Event{Time: now.Add(-time.Hour), Type: events.TypeOpenerResponse, CarID: 1, Door: "main", Action: "close", Result: events.ResultSuccess}))
+	assert.NoError(t, db.Insert(events.Event{Time: now, Type: events.TypeOpenerResponse, CarID: 2, Door: "side", Action: "open", Result: events.ResultFailure, Message: "unauthorized"}))
+
+	results, err := db.Query(Filter{CarID: 1})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, events.TypeEvaluation, results[0].Type) // oldest first
+
+	results, err = db.Query(Filter{Door: "side"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "unauthorized", results[0].Message)
+
+	results, err = db.Query(Filter{Since: now.Add(-90 * time.Minute), Until: now.Add(-30 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, events.ResultSuccess, results[0].Result)
+
+	results, err = db.Query(Filter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 2, results[0].CarID) // limit keeps the most recent events
+}
+
+func Test_RecordAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := Open(path, time.Hour)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Insert(events.Event{Time: time.Now().Add(-2 * time.Hour), Type: events.TypeEvaluation, CarID: 1}))
+	db.Record() // prunes expired events when recording starts
+	events.Publish(events.Event{Type: events.TypeCooldown, CarID: 1, Door: "main", Result: events.ResultSuppressed})
+	assert.NoError(t, db.Close()) // flushes queued events
+
+	db, err = Open(path, 0)
+	assert.NoError(t, err)
+	defer db.Close()
+	results, err := db.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, events.TypeCooldown, results[0].Type)
+}
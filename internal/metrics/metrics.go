@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tesla_youq"
+
+var (
+	// count of mqtt messages received, by car and topic (e.g. latitude, longitude, geofence)
+	MqttMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_total",
+		Help:      "MQTT messages received per car and topic.",
+	}, []string{"car_id", "topic"})
+
+	// count of mqtt messages that weren't routed to a car, by reason (e.g. unknown_car, invalid_payload)
+	MqttMessagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_dropped_total",
+		Help:      "MQTT messages dropped instead of being routed to a car.",
+	}, []string{"reason"})
+
+	// count of geofence transitions that produced an action, by geofence type and action
+	GeofenceTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "geofence_transitions_total",
+		Help:      "Geofence transitions that triggered a garage door action.",
+	}, []string{"car_id", "door", "geofence_type", "action"})
+
+	DoorActionsAttempted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "door_actions_attempted_total",
+		Help:      "Garage door actions attempted per garage door.",
+	}, []string{"door", "action"})
+
+	DoorActionsSucceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "door_actions_succeeded_total",
+		Help:      "Garage door actions that completed successfully per garage door.",
+	}, []string{"door", "action"})
+
+	DoorActionsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "door_actions_failed_total",
+		Help:      "Garage door actions that failed per garage door.",
+	}, []string{"door", "action"})
+
+	// latency of calls to the garage door opener api, by operation (e.g. login, device_state, set_door_state)
+	OpenerLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "opener_request_duration_seconds",
+		Help:      "Latency of garage door opener API requests.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	// count of actions that were not executed because the garage door was on cooldown
+	CooldownSuppressions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cooldown_suppressions_total",
+		Help:      "Garage door actions suppressed because the door was on cooldown.",
+	}, []string{"door", "action"})
+)
+
+var (
+	carGauges      = map[*util.Car][]prometheus.Collector{} // per-car gauges, so they can be replaced on config reload
+	carGaugesMutex sync.Mutex
+)
+
+// registers per-car gauges for last location fix age and current distance from the garage door, replacing any
+// previously registered for the car, e.g. if it moved to another garage door
+func RegisterCar(car *util.Car) {
+	carGaugesMutex.Lock()
+	defer carGaugesMutex.Unlock()
+	unregisterCar(car)
+
+	// car state is read through snapshots since it's updated by other goroutines while metrics are scraped
+	labels := prometheus.Labels{"car_id": strconv.Itoa(car.ID), "door": car.Snapshot().GarageDoor.Name}
+	fixAge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "car_last_fix_age_seconds",
+		Help:        "Seconds since the last location or geofence update was received for the car.",
+		ConstLabels: labels,
+	}, func() float64 {
+		lastUpdate := car.Snapshot().LastUpdate
+		if lastUpdate.IsZero() {
+			return -1
+		}
+		return time.Since(lastUpdate).Seconds()
+	})
+	distance := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "car_distance_kilometers",
+		Help:        "Current distance of the car from its garage door's circular geofence center.",
+		ConstLabels: labels,
+	}, func() float64 {
+		return car.Snapshot().CurDistance
+	})
+	collectors := []prometheus.Collector{fixAge, distance}
+	if mailbox := car.LocationUpdate; mailbox != nil {
+		collectors = append(collectors,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace:   namespace,
+				Name:        "car_location_queue_depth",
+				Help:        "Location updates waiting for the car's next geofence check, which coalesces them into one.",
+				ConstLabels: labels,
+			}, func() float64 {
+				return float64(mailbox.Depth())
+			}),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace:   namespace,
+				Name:        "car_location_updates_coalesced_total",
+				Help:        "Location updates replaced by a newer update before the car's geofence check picked them up.",
+				ConstLabels: labels,
+			}, func() float64 {
+				coalesced, _ := mailbox.Dropped()
+				return float64(coalesced)
+			}),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace:   namespace,
+				Name:        "car_location_updates_stale_total",
+				Help:        "Location updates discarded because they were older than the latest update for the car.",
+				ConstLabels: labels,
+			}, func() float64 {
+				_, stale := mailbox.Dropped()
+				return float64(stale)
+			}),
+		)
+	}
+	prometheus.MustRegister(collectors...)
+	carGauges[car] = collectors
+}
+
+// removes the per-car gauges for a car, e.g. when the car is removed from the config
+func UnregisterCar(car *util.Car) {
+	carGaugesMutex.Lock()
+	defer carGaugesMutex.Unlock()
+	unregisterCar(car)
+}
+
+func unregisterCar(car *util.Car) {
+	for _, c := range carGauges[car] {
+		prometheus.Unregister(c)
+	}
+	delete(carGauges, car)
+}
+
+// observes the latency of an opener api call started at start
+func ObserveOpenerLatency(operation string, start time.Time) {
+	OpenerLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// returns handler for the /metrics endpoint
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RegisterCar(t *testing.T) {
+	car := &util.Car{ID: 1, GarageDoor: &util.GarageDoor{Name: "main"}, LocationUpdate: util.NewLocationMailbox()}
+	RegisterCar(car)
+	defer UnregisterCar(car)
+	collectors := carGauges[car]
+	assert.Len(t, collectors, 5)
+	fixAge, distance := collectors[0], collectors[1]
+	assert.Equal(t, float64(-1), testutil.ToFloat64(fixAge)) // no update received yet
+
+	car.Lock()
+	car.LastUpdate = time.Now().Add(-time.Minute)
+	car.CurDistance = 1.5
+	car.Unlock()
+	assert.InDelta(t, 60, testutil.ToFloat64(fixAge), 1)
+	assert.Equal(t, 1.5, testutil.ToFloat64(distance))
+
+	// registering again replaces the gauges, e.g. when the car moves to another garage door on reload
+	car.GarageDoor = &util.GarageDoor{Name: "other"}
+	RegisterCar(car)
+	assert.Len(t, carGauges, 1)
+	assert.Equal(t, 1.5, testutil.ToFloat64(carGauges[car][1]))
+
+	UnregisterCar(car)
+	assert.Empty(t, carGauges)
+}
+
+func Test_RegisterCar_ConcurrentUpdates(t *testing.T) {
+	car := &util.Car{ID: 2, GarageDoor: &util.GarageDoor{Name: "main"}}
+	RegisterCar(car)
+	defer UnregisterCar(car)
+
+	// scrapes while the car is updated, which the race detector flags if gauges read the car unsynchronized
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			car.Lock()
+			car.LastUpdate = time.Now()
+			car.CurDistance = float64(i)
+			car.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		for _, c := range carGauges[car] {
+			testutil.ToFloat64(c)
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, float64(99), testutil.ToFloat64(carGauges[car][1]))
+}
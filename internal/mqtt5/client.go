@@ -0,0 +1,363 @@
+package mqtt5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	operationTimeout  = 30 * time.Second // maximum time to wait for a publish, subscribe, or unsubscribe to be acknowledged
+	minReconnectDelay = time.Second
+	deliveryQueue     = 100 // received messages waiting to be passed to their handlers in order before receiving blocks
+)
+
+// implements the paho v3 mqtt.Client interface over an mqtt v5 connection, so the rest of the app works the same with
+// either protocol version; autopaho reconnects automatically, and the OnConnect handler of the options is called after
+// every connection like the v3 client, so subscriptions are restored the same way
+type Client struct {
+	opts          *mqtt.ClientOptions
+	sessionExpiry uint32 // seconds the broker keeps the session after disconnecting
+	manager       *autopaho.ConnectionManager
+	cancel        context.CancelFunc
+	connected     atomic.Bool
+	routes        map[string]mqtt.MessageHandler // message handlers by topic filter
+	deliveries    chan delivery                  // messages waiting to be passed to their handlers in order; nil if order doesn't matter
+	mutex         sync.RWMutex
+}
+
+// a received message and a handler of a topic filter it matched
+type delivery struct {
+	handler mqtt.MessageHandler
+	message mqtt.Message
+}
+
+var _ mqtt.Client = (*Client)(nil)
+
+// error for a disconnect or a failed operation, including the v5 reason code sent by the broker
+type ReasonError struct {
+	Operation string
+	Code      byte
+	Reason    string // optional reason string sent by the broker
+}
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+func (e *ReasonError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s failed with reason code 0x%02x", e.Operation, e.Code)
+	}
+	return fmt.Sprintf("%s failed with reason code 0x%02x: %s", e.Operation, e.Code, e.Reason)
+}
+
+// creates an mqtt v5 client from paho v3 options; servers, client id, credentials, tls, keep alive, clean session,
+// will, order matters, and the OnConnect and OnConnectionLost handlers are used
+func NewClient(opts *mqtt.ClientOptions, sessionExpiry uint32) *Client {
+	c := &Client{opts: opts, sessionExpiry: sessionExpiry, routes: map[string]mqtt.MessageHandler{}}
+	if opts.Order {
+		c.deliveries = make(chan delivery, deliveryQueue)
+		go c.deliver()
+	}
+	return c
+}
+
+// connects to the first available server; the token fails if the first connection attempt fails, after which
+// reconnects are automatic
+func (c *Client) Connect() mqtt.Token {
+	t := newToken()
+	go func() { t.complete(c.connect()) }()
+	return t
+}
+
+func (c *Client) connect() error {
+	o := c.opts
+	firstAttempt := make(chan error, 1)
+	attempted := func(err error) {
+		select {
+		case firstAttempt <- err:
+		default:
+		}
+	}
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    o.Servers,
+		TlsCfg:                        o.TLSConfig,
+		KeepAlive:                     uint16(o.KeepAlive),
+		CleanStartOnInitialConnection: o.CleanSession,
+		SessionExpiryInterval:         c.sessionExpiry,
+		ConnectTimeout:                o.ConnectTimeout,
+		ReconnectBackoff:              autopaho.NewExponentialBackoff(minReconnectDelay, maxReconnectDelay(o), minReconnectDelay, 2),
+		ConnectUsername:               o.Username,
+		ConnectPassword:               []byte(o.Password),
+		OnConnectionUp: func(manager *autopaho.ConnectionManager, connack *paho.Connack) {
+			// the manager is set before OnConnect is called, so its subscriptions can use the connection before the
+			// first Connect returns
+			c.mutex.Lock()
+			c.manager = manager
+			c.mutex.Unlock()
+			c.connected.Store(true)
+			attempted(nil)
+			logger.Debugf("MQTT v5 connection up, session present: %t", connack.SessionPresent)
+			if c.opts.OnConnect != nil {
+				// called in a new goroutine like the v3 client, so waiting on tokens doesn't block the connection
+				go c.opts.OnConnect(c)
+			}
+		},
+		OnConnectError: func(err error) {
+			var connackErr *autopaho.ConnackError
+			if errors.As(err, &connackErr) {
+				err = &ReasonError{Operation: "connect", Code: connackErr.ReasonCode, Reason: connackErr.Reason}
+			}
+			attempted(err)
+			logger.Debugf("MQTT v5 connection attempt failed: %v", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          o.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){c.route},
+			OnClientError:     c.connectionLost,
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				err := &ReasonError{Operation: "connection", Code: d.ReasonCode}
+				if d.Properties != nil {
+					err.Reason = d.Properties.ReasonString
+				}
+				c.connectionLost(err)
+			},
+		},
+	}
+	if o.WillEnabled {
+		cfg.WillMessage = &paho.WillMessage{Topic: o.WillTopic, Payload: o.WillPayload, QoS: o.WillQos, Retain: o.WillRetained}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	c.cancel = cancel
+	c.mutex.Unlock()
+	if _, err := autopaho.NewConnection(ctx, cfg); err != nil {
+		c.stop()
+		return err
+	}
+	if err := <-firstAttempt; err != nil {
+		c.stop()
+		return err
+	}
+	return nil
+}
+
+// stops connecting after a failed first connection attempt
+func (c *Client) stop() {
+	c.mutex.Lock()
+	cancel := c.cancel
+	c.manager, c.cancel = nil, nil
+	c.mutex.Unlock()
+	cancel()
+}
+
+// returns the maximum delay between reconnect attempts from the v3 options, which must exceed the minimum delay
+func maxReconnectDelay(o *mqtt.ClientOptions) time.Duration {
+	if d := o.MaxReconnectInterval; d > minReconnectDelay {
+		return d
+	}
+	return 2 * minReconnectDelay
+}
+
+// marks the client disconnected and calls the OnConnectionLost handler; autopaho reconnects in the background
+func (c *Client) connectionLost(err error) {
+	if !c.connected.Swap(false) {
+		return // already reported, e.g. a server disconnect followed by the connection closing
+	}
+	if c.opts.OnConnectionLost != nil {
+		c.opts.OnConnectionLost(c, err)
+	} else {
+		logger.Warnf("MQTT connection lost, reconnecting: %v", err)
+	}
+}
+
+func (c *Client) IsConnected() bool {
+	return c.connected.Load()
+}
+
+func (c *Client) IsConnectionOpen() bool {
+	return c.connected.Load()
+}
+
+// disconnects cleanly, waiting up to quiesce milliseconds, and stops reconnecting
+func (c *Client) Disconnect(quiesce uint) {
+	c.mutex.Lock()
+	manager, cancel := c.manager, c.cancel
+	c.manager, c.cancel = nil, nil
+	c.mutex.Unlock()
+	if manager == nil {
+		return
+	}
+	c.connected.Store(false)
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+	defer cancelTimeout()
+	if err := manager.Disconnect(ctx); err != nil {
+		logger.Debugf("MQTT v5 disconnect: %v", err)
+	}
+	cancel()
+}
+
+// publishes a string, []byte, or bytes.Buffer payload
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case []byte:
+		data = p
+	case bytes.Buffer:
+		data = p.Bytes()
+	case *bytes.Buffer:
+		data = p.Bytes()
+	default:
+		return completedToken(fmt.Errorf("unknown payload type %T", payload))
+	}
+	return c.do(func(ctx context.Context, manager *autopaho.ConnectionManager) error {
+		resp, err := manager.Publish(ctx, &paho.Publish{Topic: topic, QoS: qos, Retain: retained, Payload: data})
+		if resp != nil && resp.ReasonCode >= 0x80 {
+			reason := &ReasonError{Operation: "publish to " + topic, Code: resp.ReasonCode}
+			if resp.Properties != nil {
+				reason.Reason = resp.Properties.ReasonString
+			}
+			return reason
+		}
+		return err
+	})
+}
+
+// subscribes to a topic filter; like the v3 client, callback is called for one message at a time in the order they
+// were received if order matters, or in a new goroutine for each message if it doesn't
+func (c *Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	sub := &paho.Subscribe{}
+	for topic, qos := range filters {
+		sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: topic, QoS: qos})
+		c.AddRoute(topic, callback)
+	}
+	return c.do(func(ctx context.Context, manager *autopaho.ConnectionManager) error {
+		suback, err := manager.Subscribe(ctx, sub)
+		if suback != nil {
+			for i, code := range suback.Reasons {
+				if code >= 0x80 && i < len(sub.Subscriptions) {
+					reason := &ReasonError{Operation: "subscribe to " + sub.Subscriptions[i].Topic, Code: code}
+					if suback.Properties != nil {
+						reason.Reason = suback.Properties.ReasonString
+					}
+					return reason
+				}
+			}
+		}
+		return err
+	})
+}
+
+func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
+	c.mutex.Lock()
+	for _, topic := range topics {
+		delete(c.routes, topic)
+	}
+	c.mutex.Unlock()
+	return c.do(func(ctx context.Context, manager *autopaho.ConnectionManager) error {
+		_, err := manager.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+		return err
+	})
+}
+
+// adds a message handler for a topic filter without subscribing
+func (c *Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.routes[topic] = callback
+}
+
+// the v3 package only creates options readers for its own clients, so one is created from the same options without
+// connecting
+func (c *Client) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewClient(c.opts).OptionsReader()
+}
+
+// runs an operation on the connection in the background, returning a token that completes with its result
+func (c *Client) do(operation func(context.Context, *autopaho.ConnectionManager) error) mqtt.Token {
+	c.mutex.RLock()
+	manager := c.manager
+	c.mutex.RUnlock()
+	if manager == nil || !c.connected.Load() {
+		return completedToken(mqtt.ErrNotConnected)
+	}
+	t := newToken()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		defer cancel()
+		t.complete(operation(ctx, manager))
+	}()
+	return t
+}
+
+// passes a received message to the handlers of every matching topic filter; if order matters, it's queued behind the
+// messages received before it
+func (c *Client) route(pr paho.PublishReceived) (bool, error) {
+	msg := &message{publish: pr.Packet}
+	var handlers []mqtt.MessageHandler
+	c.mutex.RLock()
+	for filter, handler := range c.routes {
+		if match(filter, msg.Topic()) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mutex.RUnlock()
+	if len(handlers) == 0 {
+		logger.Debugf("No handler for MQTT v5 message on topic %s", msg.Topic())
+		return false, nil
+	}
+	for _, handler := range handlers {
+		if c.deliveries != nil {
+			c.deliveries <- delivery{handler: handler, message: msg}
+		} else {
+			go handler(c, msg)
+		}
+	}
+	return true, nil
+}
+
+// passes queued messages to their handlers one at a time, in the order they were received
+func (c *Client) deliver() {
+	for d := range c.deliveries {
+		d.handler(c, d.message)
+	}
+}
+
+// returns true if a topic matches a topic filter with + and # wildcards
+func match(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,167 @@
+package mqtt5
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_match(t *testing.T) {
+	assert.True(t, match("teslamate/cars/1/latitude", "teslamate/cars/1/latitude"))
+	assert.True(t, match("teslamate/cars/+/latitude", "teslamate/cars/1/latitude"))
+	assert.True(t, match("teslamate/cars/#", "teslamate/cars/1/latitude"))
+	assert.True(t, match("#", "teslamate/cars/1/latitude"))
+	assert.False(t, match("teslamate/cars/+", "teslamate/cars/1/latitude"))
+	assert.False(t, match("teslamate/cars/1/latitude", "teslamate/cars/1"))
+	assert.False(t, match("teslamate/cars/2/latitude", "teslamate/cars/1/latitude"))
+}
+
+func Test_route(t *testing.T) {
+	c := NewClient(mqtt.NewClientOptions(), 0)
+	received := make(chan mqtt.Message, 2)
+	c.AddRoute("teslamate/cars/+/latitude", func(client mqtt.Client, message mqtt.Message) {
+		assert.Equal(t, c, client)
+		received <- message
+	})
+	c.AddRoute("tesla-youq/command", func(mqtt.Client, mqtt.Message) {
+		t.Error("unexpected message on command topic")
+	})
+
+	handled, err := c.route(paho.PublishReceived{Packet: &paho.Publish{Topic: "teslamate/cars/1/latitude", QoS: 1, Payload: []byte("46.19")}})
+	assert.NoError(t, err)
+	assert.True(t, handled)
+	select {
+	case message := <-received:
+		assert.Equal(t, "teslamate/cars/1/latitude", message.Topic())
+		assert.Equal(t, "46.19", string(message.Payload()))
+		assert.Equal(t, byte(1), message.Qos())
+	case <-time.After(time.Second):
+		t.Fatal("message wasn't routed")
+	}
+
+	// unsubscribing removes the route even while disconnected
+	c.Unsubscribe("teslamate/cars/+/latitude")
+	handled, _ = c.route(paho.PublishReceived{Packet: &paho.Publish{Topic: "teslamate/cars/1/latitude"}})
+	assert.False(t, handled)
+}
+
+func Test_route_InOrder(t *testing.T) {
+	opts := mqtt.NewClientOptions().SetOrderMatters(true)
+	c := NewClient(opts, 0)
+	received := make(chan string, 10)
+	c.AddRoute("teslamate/cars/+/latitude", func(_ mqtt.Client, message mqtt.Message) {
+		if string(message.Payload()) == "0" {
+			time.Sleep(10 * time.Millisecond) // a slow handler doesn't let later messages overtake it
+		}
+		received <- string(message.Payload())
+	})
+
+	for i := 0; i < 10; i++ {
+		c.route(paho.PublishReceived{Packet: &paho.Publish{Topic: "teslamate/cars/1/latitude", Payload: []byte(fmt.Sprint(i))}})
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case payload := <-received:
+			assert.Equal(t, fmt.Sprint(i), payload)
+		case <-time.After(time.Second):
+			t.Fatal("message wasn't routed")
+		}
+	}
+}
+
+func Test_NotConnected(t *testing.T) {
+	c := NewClient(mqtt.NewClientOptions(), 0)
+	assert.False(t, c.IsConnected())
+
+	token := c.Publish("topic", 1, false, "payload")
+	assert.True(t, token.WaitTimeout(time.Second))
+	assert.ErrorIs(t, token.Error(), mqtt.ErrNotConnected)
+
+	token = c.Publish("topic", 1, false, 1)
+	assert.True(t, token.Wait())
+	assert.ErrorContains(t, token.Error(), "unknown payload type int")
+
+	token = c.Subscribe("topic", 1, func(mqtt.Client, mqtt.Message) {})
+	token.Wait()
+	assert.ErrorIs(t, token.Error(), mqtt.ErrNotConnected)
+	c.Disconnect(0) // no-op when never connected
+}
+
+func Test_ReasonError(t *testing.T) {
+	assert.Equal(t, "connect failed with reason code 0x87: not authorized", (&ReasonError{Operation: "connect", Code: 0x87, Reason: "not authorized"}).Error())
+	assert.Equal(t, "subscribe to topic failed with reason code 0x80", (&ReasonError{Operation: "subscribe to topic", Code: 0x80}).Error())
+}
+
+// accepts one connection on a local port and answers it like a broker: connects are acknowledged, subscribes are
+// granted, and the topics subscribed are sent to subscribed
+func fakeBroker(t *testing.T, subscribed chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			packet, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			switch p := packet.Content.(type) {
+			case *packets.Connect:
+				(&packets.Connack{}).WriteTo(conn)
+			case *packets.Subscribe:
+				suback := &packets.Suback{PacketID: p.PacketID}
+				for _, s := range p.Subscriptions {
+					suback.Reasons = append(suback.Reasons, s.QoS)
+					subscribed <- s.Topic
+				}
+				suback.WriteTo(conn)
+			case *packets.Pingreq:
+				packets.NewControlPacket(packets.PINGRESP).WriteTo(conn)
+			case *packets.Disconnect:
+				return
+			}
+		}
+	}()
+	return "mqtt://" + listener.Addr().String()
+}
+
+func Test_Connect_SubscribesOnConnect(t *testing.T) {
+	subscribed := make(chan string, 1)
+	subscribeErr := make(chan error, 1)
+	opts := mqtt.NewClientOptions().AddBroker(fakeBroker(t, subscribed))
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		// waits for the subscription like the app does, which blocks the connection unless called in a goroutine
+		token := client.Subscribe("teslamate/cars/1/latitude", 1, func(mqtt.Client, mqtt.Message) {})
+		token.Wait()
+		subscribeErr <- token.Error()
+	})
+	c := NewClient(opts, 0)
+
+	token := c.Connect()
+	assert.True(t, token.WaitTimeout(5*time.Second))
+	assert.NoError(t, token.Error())
+	defer c.Disconnect(100)
+	select {
+	case err := <-subscribeErr:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnect didn't subscribe")
+	}
+	select {
+	case topic := <-subscribed:
+		assert.Equal(t, "teslamate/cars/1/latitude", topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't receive the subscription")
+	}
+	assert.True(t, c.IsConnected())
+}
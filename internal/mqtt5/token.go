@@ -0,0 +1,90 @@
+package mqtt5
+
+import (
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// implements mqtt.Token for operations that complete in the background
+type token struct {
+	done chan struct{}
+	err  error
+}
+
+// implements mqtt.Message for a received v5 publish
+type message struct {
+	publish *paho.Publish
+}
+
+func newToken() *token {
+	return &token{done: make(chan struct{})}
+}
+
+// returns a token that has already completed with err
+func completedToken(err error) *token {
+	t := newToken()
+	t.complete(err)
+	return t
+}
+
+func (t *token) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *token) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (m *message) Duplicate() bool {
+	return m.publish.Duplicate()
+}
+
+func (m *message) Qos() byte {
+	return m.publish.QoS
+}
+
+func (m *message) Retained() bool {
+	return m.publish.Retain
+}
+
+func (m *message) Topic() string {
+	return m.publish.Topic
+}
+
+func (m *message) MessageID() uint16 {
+	return m.publish.PacketID
+}
+
+func (m *message) Payload() []byte {
+	return m.publish.Payload
+}
+
+// acknowledgements are sent automatically by paho
+func (m *message) Ack() {}
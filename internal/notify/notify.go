@@ -0,0 +1,280 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeNtfy     = "ntfy"
+	TypeGotify   = "gotify"
+	TypePushover = "pushover"
+	TypeTelegram = "telegram"
+	TypeWebhook  = "webhook"
+
+	// events that can be filtered per notification sink
+	EventSuccess    = "success"    // door action completed
+	EventFailure    = "failure"    // door action failed
+	EventTimeout    = "timeout"    // door didn't reach the requested state in time
+	EventSuppressed = "suppressed" // geofence triggered action skipped due to cooldown or pause
+	EventAttention  = "attention"  // door settled in an unexpected state, per the notify unexpected_state_policy
+	EventConfirm    = "confirm"    // geofence triggered close is awaiting confirmation, for doors with confirm_close enabled
+
+	defaultTitle   = "Tesla-YouQ"
+	defaultMessage = `Garage door {{.Door}} {{.Action}} {{.Result}}{{if .CarID}} for car {{.CarID}}{{if .Lat}} at {{printf "%.5f" .Lat}}, {{printf "%.5f" .Lng}}{{end}}{{end}}{{if .Message}}: {{.Message}}{{end}}`
+
+	queueSize = 100
+)
+
+type (
+	// sends a rendered notification to an external service
+	Notifier interface {
+		Send(n Notification) error
+	}
+
+	// notifier that receives responses to confirmation actions directly from its service
+	Listener interface {
+		Listen(resolve Resolver)
+	}
+
+	// rendered notification, along with the event that triggered it
+	Notification struct {
+		Title    string
+		Message  string
+		Category string   // filterable event, e.g. failure
+		Actions  []Action // responses offered for confirm notifications
+		events.Event
+	}
+
+	// response to a pending confirmation offered as a notification button
+	Action struct {
+		Label   string
+		ID      string // confirmation id
+		Confirm bool   // true to close, false to cancel
+		Url     string // control api endpoint that resolves the confirmation; empty if public_url isn't set
+		Token   string // single use bearer token accepted by Url, only valid until the confirmation is resolved or expires
+	}
+
+	// resolves a pending confirmation, e.g. geo.ResolveConfirmation
+	Resolver func(id string, confirm bool) error
+
+	// returns the bearer token that authorizes resolving a pending confirmation, e.g. geo.ConfirmationNonce
+	NonceFunc func(id string) string
+
+	// notifier with its event filter and message templates
+	sink struct {
+		name     string
+		notifier Notifier
+		events   map[string]bool
+		title    *template.Template
+		message  *template.Template
+		queue    chan Notification
+	}
+
+	// sends notifications to all configured sinks as events are published
+	Dispatcher struct {
+		sinks     []*sink
+		publicUrl string
+		nonce     NonceFunc
+		resolve   Resolver
+	}
+)
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// creates a dispatcher for the configured notification sinks; returns an error if any are invalid
+func New(configs []util.Notification) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for i, c := range configs {
+		s, err := newSink(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification #%d: %w", i, err)
+		}
+		d.sinks = append(d.sinks, s)
+	}
+	return d, nil
+}
+
+func newSink(c util.Notification) (*sink, error) {
+	notifier, err := newNotifier(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sink{name: c.Type, notifier: notifier, events: map[string]bool{}}
+	for _, e := range c.Events {
+		switch e {
+		case EventSuccess, EventFailure, EventTimeout, EventSuppressed, EventAttention, EventConfirm:
+			s.events[e] = true
+		default:
+			return nil, fmt.Errorf("unknown event %s, must be one of %s, %s, %s, %s, %s, %s", e, EventSuccess, EventFailure, EventTimeout, EventSuppressed, EventAttention, EventConfirm)
+		}
+	}
+
+	title, message := c.Title, c.Message
+	if title == "" {
+		title = defaultTitle
+	}
+	if message == "" {
+		message = defaultMessage
+	}
+	if s.title, err = template.New("title").Parse(title); err != nil {
+		return nil, fmt.Errorf("unable to parse title template: %w", err)
+	}
+	if s.message, err = template.New("message").Parse(message); err != nil {
+		return nil, fmt.Errorf("unable to parse message template: %w", err)
+	}
+	return s, nil
+}
+
+func newNotifier(c util.Notification) (Notifier, error) {
+	switch c.Type {
+	case TypeNtfy:
+		if c.Url == "" {
+			return nil, fmt.Errorf("url is required for %s", c.Type)
+		}
+		return &Ntfy{Url: c.Url, Token: c.Token}, nil
+	case TypeGotify:
+		if c.Url == "" || c.Token == "" {
+			return nil, fmt.Errorf("url and token are required for %s", c.Type)
+		}
+		return &Gotify{Url: c.Url, Token: c.Token}, nil
+	case TypePushover:
+		if c.Token == "" || c.User == "" {
+			return nil, fmt.Errorf("token and user are required for %s", c.Type)
+		}
+		return &Pushover{Url: c.Url, Token: c.Token, User: c.User}, nil
+	case TypeTelegram:
+		if c.Token == "" || c.ChatID == "" {
+			return nil, fmt.Errorf("token and chat_id are required for %s", c.Type)
+		}
+		return &Telegram{Url: c.Url, Token: c.Token, ChatID: c.ChatID}, nil
+	case TypeWebhook:
+		if c.Url == "" {
+			return nil, fmt.Errorf("url is required for %s", c.Type)
+		}
+		return &Webhook{Url: c.Url, Token: c.Token}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %s, must be one of %s, %s, %s, %s, %s", c.Type, TypeNtfy, TypeGotify, TypePushover, TypeTelegram, TypeWebhook)
+	}
+}
+
+// offers close and cancel actions on confirm notifications; publicUrl and nonce are used to build control api urls
+// for sinks with http actions, which never receive the api token, and resolve is called when a sink receives a
+// response directly, e.g. telegram
+func (d *Dispatcher) EnableConfirmations(publicUrl string, nonce NonceFunc, resolve Resolver) {
+	if publicUrl == "" {
+		logger.Warn("public_url not defined or control api disabled, confirmation actions will only be offered through telegram")
+	}
+	d.publicUrl = strings.TrimSuffix(publicUrl, "/")
+	d.nonce = nonce
+	d.resolve = resolve
+}
+
+// subscribes to app events and starts sending notifications to each sink in the background
+func (d *Dispatcher) Start() {
+	for _, s := range d.sinks {
+		s.queue = make(chan Notification, queueSize)
+		go s.run()
+		if l, ok := s.notifier.(Listener); ok && d.resolve != nil {
+			go l.Listen(d.resolve)
+		}
+	}
+	events.Subscribe(d.handleEvent)
+}
+
+func (d *Dispatcher) handleEvent(e events.Event) {
+	category := Category(e)
+	if category == "" {
+		return
+	}
+	for _, s := range d.sinks {
+		if len(s.events) > 0 && !s.events[category] {
+			continue
+		}
+		n, err := s.render(category, e)
+		if err != nil {
+			logger.Warnf("Unable to render %s notification: %v", s.name, err)
+			continue
+		}
+		if category == EventConfirm && d.resolve != nil {
+			n.Actions = d.actions(e.ID)
+		}
+		// don't block the publishing goroutine if a notification service is slow or unreachable
+		select {
+		case s.queue <- n:
+		default:
+			logger.Warnf("%s notification queue is full, dropping notification: %s", s.name, n.Message)
+		}
+	}
+}
+
+// returns the filterable event for an app event, or an empty string if it doesn't trigger notifications
+func Category(e events.Event) string {
+	switch {
+	case e.Type == events.TypeOpenerResponse:
+		return e.Result // success, failure, or timeout
+	case e.Type == events.TypeSuppressed:
+		return EventSuppressed
+	case e.Type == events.TypeCooldown && e.Result == events.ResultSuppressed:
+		return EventSuppressed
+	case e.Type == events.TypeAttention:
+		return EventAttention
+	case e.Type == events.TypeConfirmation && e.Result == events.ResultRequested:
+		return EventConfirm
+	default:
+		return ""
+	}
+}
+
+// returns close and cancel actions for a pending confirmation
+func (d *Dispatcher) actions(id string) []Action {
+	actions := []Action{{Label: "Close", ID: id, Confirm: true}, {Label: "Cancel", ID: id, Confirm: false}}
+	if d.publicUrl != "" && d.nonce != nil {
+		nonce := d.nonce(id)
+		for i := range actions {
+			command := "cancel"
+			if actions[i].Confirm {
+				command = "confirm"
+			}
+			actions[i].Url = fmt.Sprintf("%s/api/v1/confirmations/%s/%s", d.publicUrl, id, command)
+			actions[i].Token = nonce
+		}
+	}
+	return actions
+}
+
+func (s *sink) render(category string, e events.Event) (Notification, error) {
+	n := Notification{Category: category, Event: e}
+	var title, message bytes.Buffer
+	if err := s.title.Execute(&title, e); err != nil {
+		return n, err
+	}
+	if err := s.message.Execute(&message, e); err != nil {
+		return n, err
+	}
+	n.Title = title.String()
+	n.Message = message.String()
+	return n, nil
+}
+
+func (s *sink) run() {
+	for n := range s.queue {
+		logger.Debugf("Sending %s notification: %s", s.name, n.Message)
+		if err := s.notifier.Send(n); err != nil {
+			logger.Warnf("Unable to send %s notification: %v", s.name, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// records notifications instead of sending them
+type fakeNotifier struct {
+	sent []Notification
+}
+
+func (f *fakeNotifier) Send(n Notification) error {
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// returns a dispatcher with a single sink that delivers synchronously to a fakeNotifier
+func newTestDispatcher(t *testing.T, c util.Notification) (*Dispatcher, *fakeNotifier) {
+	c.Type = TypeWebhook
+	c.Url = "http://localhost"
+	d, err := New([]util.Notification{c})
+	assert.NoError(t, err)
+	f := &fakeNotifier{}
+	d.sinks[0].notifier = f
+	d.sinks[0].queue = make(chan Notification, queueSize)
+	return d, f
+}
+
+func drain(d *Dispatcher) {
+	for _, s := range d.sinks {
+		close(s.queue)
+		s.run()
+	}
+}
+
+func Test_Category(t *testing.T) {
+	assert.Equal(t, EventSuccess, Category(events.Event{Type: events.TypeOpenerResponse, Result: events.ResultSuccess}))
+	assert.Equal(t, EventTimeout, Category(events.Event{Type: events.TypeOpenerResponse, Result: events.ResultTimeout}))
+	assert.Equal(t, EventSuppressed, Category(events.Event{Type: events.TypeSuppressed}))
+	assert.Equal(t, EventSuppressed, Category(events.Event{Type: events.TypeCooldown, Result: events.ResultSuppressed}))
+	assert.Equal(t, EventAttention, Category(events.Event{Type: events.TypeAttention}))
+	assert.Equal(t, "", Category(events.Event{Type: events.TypeCooldown, Result: events.ResultStarted}))
+	assert.Equal(t, "", Category(events.Event{Type: events.TypeEvaluation}))
+}
+
+func Test_handleEvent_Filter(t *testing.T) {
+	d, f := newTestDispatcher(t, util.Notification{Events: []string{EventFailure, EventTimeout}})
+
+	d.handleEvent(events.Event{Type: events.TypeOpenerResponse, Door: "main", Action: "close", Result: events.ResultSuccess})
+	d.handleEvent(events.Event{Type: events.TypeOpenerResponse, Door: "main", Action: "close", Result: events.ResultFailure})
+	d.handleEvent(events.Event{Type: events.TypeEvaluation, Door: "main"})
+	drain(d)
+
+	assert.Len(t, f.sent, 1)
+	assert.Equal(t, EventFailure, f.sent[0].Category)
+}
+
+func Test_handleEvent_Templates(t *testing.T) {
+	d, f := newTestDispatcher(t, util.Notification{})
+	e := events.Event{Type: events.TypeOpenerResponse, Door: "main", CarID: 1, Action: "close", Result: events.ResultSuccess, Lat: 46.192904, Lng: -123.799651}
+
+	d.handleEvent(e)
+	e.Result = events.ResultFailure
+	e.Message = "unable to reach opener"
+	d.handleEvent(e)
+	drain(d)
+
+	assert.Len(t, f.sent, 2)
+	assert.Equal(t, defaultTitle, f.sent[0].Title)
+	assert.Equal(t, "Garage door main close success for car 1 at 46.19290, -123.79965", f.sent[0].Message)
+	assert.Equal(t, "Garage door main close failure for car 1 at 46.19290, -123.79965: unable to reach opener", f.sent[1].Message)
+
+	d, f = newTestDispatcher(t, util.Notification{Title: "{{.Door}} {{.Result}}", Message: "car {{.CarID}}"})
+	d.handleEvent(e)
+	drain(d)
+	assert.Equal(t, "main failure", f.sent[0].Title)
+	assert.Equal(t, "car 1", f.sent[0].Message)
+}
+
+func Test_New_Invalid(t *testing.T) {
+	for name, c := range map[string]util.Notification{
+		"unknown type":      {Type: "carrier_pigeon"},
+		"missing url":       {Type: TypeNtfy},
+		"missing chat id":   {Type: TypeTelegram, Token: "token"},
+		"unknown event":     {Type: TypeNtfy, Url: "http://localhost", Events: []string{"explosion"}},
+		"invalid template":  {Type: TypeNtfy, Url: "http://localhost", Message: "{{.Door"},
+		"missing user key":  {Type: TypePushover, Token: "token"},
+		"missing app token": {Type: TypeGotify, Url: "http://localhost"},
+	} {
+		_, err := New([]util.Notification{c})
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_Send(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(b))
+	}))
+	defer server.Close()
+	n := Notification{Title: "title", Message: "message", Category: EventFailure, Event: events.Event{Door: "main", Action: "close"}}
+
+	assert.NoError(t, (&Ntfy{Url: server.URL + "/garage", Token: "secret"}).Send(n))
+	assert.Equal(t, "/garage", requests[0].URL.Path)
+	assert.Equal(t, "title", requests[0].Header.Get("Title"))
+	assert.Equal(t, "high", requests[0].Header.Get("Priority"))
+	assert.Equal(t, "Bearer secret", requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "message", bodies[0])
+
+	assert.NoError(t, (&Gotify{Url: server.URL, Token: "secret"}).Send(n))
+	assert.Equal(t, "/message", requests[1].URL.Path)
+	assert.Equal(t, "secret", requests[1].Header.Get("X-Gotify-Key"))
+
+	assert.NoError(t, (&Pushover{Url: server.URL, Token: "app", User: "user"}).Send(n))
+	assert.Contains(t, bodies[2], "user=user")
+
+	assert.NoError(t, (&Telegram{Url: server.URL, Token: "bot", ChatID: "42"}).Send(n))
+	assert.Equal(t, "/botbot/sendMessage", requests[3].URL.Path)
+	assert.JSONEq(t, `{"chat_id": "42", "text": "title\nmessage"}`, bodies[3])
+
+	assert.NoError(t, (&Webhook{Url: server.URL}).Send(n))
+	var payload WebhookPayload
+	assert.NoError(t, json.Unmarshal([]byte(bodies[4]), &payload))
+	assert.Equal(t, "main", payload.Door)
+	assert.Equal(t, EventFailure, payload.Category)
+}
+
+func Test_Send_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	err := (&Ntfy{Url: server.URL}).Send(Notification{})
+	assert.ErrorContains(t, err, "401")
+}
+
+func Test_handleEvent_ConfirmActions(t *testing.T) {
+	d, f := newTestDispatcher(t, util.Notification{})
+	d.EnableConfirmations("http://tesla-youq.local:8080/", func(id string) string { return "nonce-" + id }, func(id string, confirm bool) error { return nil })
+
+	d.handleEvent(events.Event{Type: events.TypeConfirmation, Door: "main", CarID: 1, Action: "close", Result: events.ResultRequested, ID: "abc"})
+	d.handleEvent(events.Event{Type: events.TypeConfirmation, Door: "main", CarID: 1, Action: "close", Result: events.ResultConfirmed, ID: "abc"})
+	drain(d)
+
+	assert.Len(t, f.sent, 1)
+	assert.Equal(t, EventConfirm, f.sent[0].Category)
+	assert.Equal(t, []Action{
+		{Label: "Close", ID: "abc", Confirm: true, Url: "http://tesla-youq.local:8080/api/v1/confirmations/abc/confirm", Token: "nonce-abc"},
+		{Label: "Cancel", ID: "abc", Confirm: false, Url: "http://tesla-youq.local:8080/api/v1/confirmations/abc/cancel", Token: "nonce-abc"},
+	}, f.sent[0].Actions)
+}
+
+func Test_Send_ConfirmActions(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(b))
+	}))
+	defer server.Close()
+	n := Notification{Title: "title", Message: "message", Category: EventConfirm, Actions: []Action{
+		{Label: "Close", ID: "abc", Confirm: true, Url: "http://localhost/api/v1/confirmations/abc/confirm", Token: "nonce-abc"},
+	}}
+
+	assert.NoError(t, (&Ntfy{Url: server.URL}).Send(n))
+	assert.Equal(t, "http, Close, http://localhost/api/v1/confirmations/abc/confirm, method=POST, headers.Authorization=Bearer nonce-abc, clear=true", requests[0].Header.Get("Actions"))
+
+	assert.NoError(t, (&Telegram{Url: server.URL, Token: "bot", ChatID: "42"}).Send(n))
+	assert.JSONEq(t, `{"chat_id": "42", "text": "title\nmessage", "reply_markup": {"inline_keyboard": [[{"text": "Close", "callback_data": "confirm:abc"}]]}}`, bodies[1])
+}
+
+func Test_Telegram_handleCallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	telegram := &Telegram{Url: server.URL, Token: "bot", ChatID: "42"}
+	resolved := map[string]bool{}
+	resolve := func(id string, confirm bool) error {
+		resolved[id] = confirm
+		return nil
+	}
+
+	var q telegramCallbackQuery
+	assert.NoError(t, json.Unmarshal([]byte(`{"id": "1", "data": "cancel:abc", "message": {"chat": {"id": 42}}}`), &q))
+	telegram.handleCallback(&q, resolve)
+	assert.NoError(t, json.Unmarshal([]byte(`{"id": "2", "data": "confirm:def", "message": {"chat": {"id": 7}}}`), &q))
+	telegram.handleCallback(&q, resolve) // ignored, unexpected chat
+
+	assert.Equal(t, map[string]bool{"abc": false}, resolved)
+}
@@ -0,0 +1,338 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPushoverUrl = "https://api.pushover.net/1/messages.json"
+	defaultTelegramUrl = "https://api.telegram.org"
+
+	telegramPollTimeout   = 50 * time.Second // long polling timeout for telegram updates
+	telegramRetryInterval = 10 * time.Second
+)
+
+type (
+	// publishes to an ntfy topic, e.g. https://ntfy.sh/my-garage
+	Ntfy struct {
+		Url   string
+		Token string // optional access token for protected topics
+	}
+
+	// sends to a gotify server using an application token
+	Gotify struct {
+		Url   string
+		Token string
+	}
+
+	// sends through the pushover api; Url overrides the default api endpoint
+	Pushover struct {
+		Url   string
+		Token string // application token
+		User  string // user or group key
+	}
+
+	// sends a message to a telegram chat through a bot; Url overrides the default api endpoint
+	Telegram struct {
+		Url    string
+		Token  string // bot token
+		ChatID string
+	}
+
+	// posts a json WebhookPayload to an arbitrary url
+	Webhook struct {
+		Url   string
+		Token string // optional bearer token
+	}
+
+	// body posted by the webhook notifier
+	WebhookPayload struct {
+		Title    string          `json:"title"`
+		Message  string          `json:"message"`
+		Category string          `json:"category"`
+		Type     string          `json:"type"`
+		Time     time.Time       `json:"time"`
+		CarID    int             `json:"car_id,omitempty"`
+		Door     string          `json:"door"`
+		Action   string          `json:"action,omitempty"`
+		Result   string          `json:"result,omitempty"`
+		Detail   string          `json:"detail,omitempty"`
+		Lat      float64         `json:"lat,omitempty"`
+		Lng      float64         `json:"lng,omitempty"`
+		ID       string          `json:"confirmation_id,omitempty"`
+		Actions  []WebhookAction `json:"actions,omitempty"` // responses to a pending confirmation; post to Url with Token as the bearer token to respond
+	}
+
+	WebhookAction struct {
+		Label   string `json:"label"`
+		Confirm bool   `json:"confirm"`
+		Url     string `json:"url,omitempty"`
+		Token   string `json:"token,omitempty"` // single use, only valid until the confirmation is resolved or expires
+	}
+
+	telegramButton struct {
+		Text         string `json:"text"`
+		CallbackData string `json:"callback_data"`
+	}
+
+	telegramUpdate struct {
+		UpdateID      int                    `json:"update_id"`
+		CallbackQuery *telegramCallbackQuery `json:"callback_query"`
+	}
+
+	telegramCallbackQuery struct {
+		ID      string `json:"id"`
+		Data    string `json:"data"`
+		Message *struct {
+			Chat struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+	}
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func (n *Ntfy) Send(notification Notification) error {
+	req, err := http.NewRequest(http.MethodPost, n.Url, strings.NewReader(notification.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", notification.Title)
+	if urgent(notification) {
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	// ntfy http actions call the control api directly from the notification
+	var actions []string
+	for _, a := range notification.Actions {
+		if a.Url != "" {
+			actions = append(actions, fmt.Sprintf("http, %s, %s, method=POST, headers.Authorization=Bearer %s, clear=true", a.Label, a.Url, a.Token))
+		}
+	}
+	if len(actions) > 0 {
+		req.Header.Set("Actions", strings.Join(actions, "; "))
+	}
+	return do(req)
+}
+
+func (g *Gotify) Send(notification Notification) error {
+	priority := 5
+	if urgent(notification) {
+		priority = 8
+	}
+	return postJson(strings.TrimSuffix(g.Url, "/")+"/message", map[string]string{"X-Gotify-Key": g.Token}, map[string]interface{}{
+		"title":    notification.Title,
+		"message":  notification.Message,
+		"priority": priority,
+	})
+}
+
+func (p *Pushover) Send(notification Notification) error {
+	endpoint := p.Url
+	if endpoint == "" {
+		endpoint = defaultPushoverUrl
+	}
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {notification.Title},
+		"message": {notification.Message},
+	}
+	if urgent(notification) {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(req)
+}
+
+func (t *Telegram) Send(notification Notification) error {
+	message := map[string]interface{}{
+		"chat_id": t.ChatID,
+		"text":    notification.Title + "\n" + notification.Message,
+	}
+	// inline buttons are answered through Listen
+	if len(notification.Actions) > 0 {
+		var buttons []telegramButton
+		for _, a := range notification.Actions {
+			buttons = append(buttons, telegramButton{Text: a.Label, CallbackData: telegramCallbackData(a)})
+		}
+		message["reply_markup"] = map[string]interface{}{"inline_keyboard": [][]telegramButton{buttons}}
+	}
+	return postJson(t.endpoint("sendMessage"), nil, message)
+}
+
+// polls the bot for inline button presses from the configured chat and resolves the confirmations they reference
+func (t *Telegram) Listen(resolve Resolver) {
+	client := &http.Client{Timeout: telegramPollTimeout + 10*time.Second}
+	offset := 0
+	for {
+		updates, err := t.getUpdates(client, offset)
+		if err != nil {
+			logger.Warnf("Unable to get telegram updates, retrying in %v: %v", telegramRetryInterval, err)
+			time.Sleep(telegramRetryInterval)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.CallbackQuery == nil {
+				continue
+			}
+			t.handleCallback(u.CallbackQuery, resolve)
+		}
+	}
+}
+
+func (t *Telegram) getUpdates(client *http.Client, offset int) ([]telegramUpdate, error) {
+	query := url.Values{
+		"offset":          {strconv.Itoa(offset)},
+		"timeout":         {strconv.Itoa(int(telegramPollTimeout.Seconds()))},
+		"allowed_updates": {`["callback_query"]`},
+	}
+	resp, err := client.Get(t.endpoint("getUpdates") + "?" + query.Encode())
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, urlErr.Err // avoid logging the bot token embedded in the url
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Ok          bool             `json:"ok"`
+		Description string           `json:"description"`
+		Result      []telegramUpdate `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if !body.Ok {
+		return nil, fmt.Errorf("telegram responded with %s: %s", resp.Status, body.Description)
+	}
+	return body.Result, nil
+}
+
+func (t *Telegram) handleCallback(q *telegramCallbackQuery, resolve Resolver) {
+	if q.Message == nil || strconv.FormatInt(q.Message.Chat.ID, 10) != t.ChatID {
+		logger.Warnf("Ignoring telegram callback from unexpected chat")
+		return
+	}
+	command, id, _ := strings.Cut(q.Data, ":")
+	reply := "Confirmation expired"
+	if command == "confirm" || command == "cancel" {
+		if err := resolve(id, command == "confirm"); err == nil {
+			reply = "Closing garage door"
+			if command == "cancel" {
+				reply = "Close cancelled"
+			}
+			logger.Infof("Confirmation %s resolved through telegram: %s", id, command)
+		}
+	}
+	if err := postJson(t.endpoint("answerCallbackQuery"), nil, map[string]string{"callback_query_id": q.ID, "text": reply}); err != nil {
+		logger.Debugf("Unable to answer telegram callback: %v", err)
+	}
+}
+
+// returns the bot api url for a method
+func (t *Telegram) endpoint(method string) string {
+	endpoint := t.Url
+	if endpoint == "" {
+		endpoint = defaultTelegramUrl
+	}
+	return fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(endpoint, "/"), t.Token, method)
+}
+
+func telegramCallbackData(a Action) string {
+	if a.Confirm {
+		return "confirm:" + a.ID
+	}
+	return "cancel:" + a.ID
+}
+
+func (w *Webhook) Send(notification Notification) error {
+	var headers map[string]string
+	if w.Token != "" {
+		headers = map[string]string{"Authorization": "Bearer " + w.Token}
+	}
+	return postJson(w.Url, headers, WebhookPayload{
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Category: notification.Category,
+		Type:     notification.Type,
+		Time:     notification.Time,
+		CarID:    notification.CarID,
+		Door:     notification.Door,
+		Action:   notification.Action,
+		Result:   notification.Result,
+		Detail:   notification.Event.Message,
+		Lat:      notification.Lat,
+		Lng:      notification.Lng,
+		ID:       notification.ID,
+		Actions:  webhookActions(notification.Actions),
+	})
+}
+
+func webhookActions(actions []Action) []WebhookAction {
+	var list []WebhookAction
+	for _, a := range actions {
+		list = append(list, WebhookAction{Label: a.Label, Confirm: a.Confirm, Url: a.Url, Token: a.Token})
+	}
+	return list
+}
+
+// returns true for notifications that should be delivered with raised priority where supported
+func urgent(notification Notification) bool {
+	return notification.Category != EventSuccess && notification.Category != EventSuppressed
+}
+
+func postJson(endpoint string, headers map[string]string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return do(req)
+}
+
+// sends the request and returns an error for non-2xx responses
+func do(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// avoid leaking tokens embedded in the url, e.g. telegram bot tokens
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("request to %s failed: %v", req.URL.Host, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
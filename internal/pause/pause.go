@@ -0,0 +1,259 @@
+package pause
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	ScopeGlobal = "global" // pauses automation for all garage doors and cars
+	ScopeDoor   = "door"   // pauses automation for a single garage door, targeted by name
+	ScopeCar    = "car"    // pauses automation triggered by a single car, targeted by teslamate car id
+
+	CommandPause  = "pause"
+	CommandResume = "resume"
+)
+
+type (
+	// pauses automatic garage door actions for a scope until a time, or indefinitely if Until is zero
+	Pause struct {
+		Scope  string    `json:"scope"`
+		Target string    `json:"target,omitempty"` // garage door name or car id; empty for global scope
+		Until  time.Time `json:"until"`
+		Reason string    `json:"reason,omitempty"`
+		Action string    `json:"action,omitempty"` // only pause open or close actions; pauses all actions if empty
+		Source string    `json:"source,omitempty"` // what manages the pause, e.g. a calendar; empty for pauses set by command
+	}
+
+	// command received from the mqtt command topic or sent by the pause and resume subcommands, e.g.
+	// {"command": "pause", "door": "main", "duration": "2h", "reason": "guests"}
+	Command struct {
+		Command  string `json:"command"`            // pause or resume
+		Door     string `json:"door,omitempty"`     // garage door name; applies to all garage doors if neither door nor car is set
+		Car      int    `json:"car,omitempty"`      // teslamate car id
+		Duration string `json:"duration,omitempty"` // how long to pause, e.g. 2h or 30m; pauses indefinitely if empty
+		Reason   string `json:"reason,omitempty"`
+	}
+)
+
+var (
+	pauses = map[string]Pause{} // active pauses keyed by scope, target, action, and source
+	mutex  sync.Mutex
+
+	scopePriority = map[string]int{ScopeGlobal: 0, ScopeDoor: 1, ScopeCar: 2}
+)
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+func (p Pause) key() string {
+	return p.Scope + "/" + p.Target + "/" + p.Action + "/" + p.Source
+}
+
+// returns true if the pause applies to an action on a garage door triggered by a car; an empty action matches
+// pauses of any action
+func (p Pause) applies(door string, carID int, action string) bool {
+	if p.Action != "" && action != "" && p.Action != action {
+		return false
+	}
+	switch p.Scope {
+	case ScopeGlobal:
+		return true
+	case ScopeDoor:
+		return p.Target == door
+	case ScopeCar:
+		return p.Target == strconv.Itoa(carID)
+	}
+	return false
+}
+
+// returns true if the pause has an expiry that has passed
+func (p Pause) Expired() bool {
+	return !p.Until.IsZero() && !p.Until.After(time.Now())
+}
+
+func (p Pause) String() string {
+	s := p.Scope
+	if p.Target != "" {
+		s += " " + p.Target
+	}
+	if p.Action != "" {
+		s += " " + p.Action + " actions"
+	}
+	if p.Until.IsZero() {
+		s += " indefinitely"
+	} else {
+		s += " until " + p.Until.Format("01/02/2006 15:04:05")
+	}
+	if p.Reason != "" {
+		s += " (" + p.Reason + ")"
+	}
+	return s
+}
+
+// adds or replaces a pause for its scope and target
+func Set(p Pause) {
+	mutex.Lock()
+	pauses[p.key()] = p
+	mutex.Unlock()
+	logger.Infof("Automation paused for %s", p)
+	events.Publish(events.Event{Type: events.TypePause, Door: doorTarget(p), CarID: carTarget(p), Result: CommandPause, Message: p.String()})
+}
+
+// removes the pause set by command for a scope and target; returns false if there was no active pause
+func Clear(scope string, target string) bool {
+	return Remove(Pause{Scope: scope, Target: target})
+}
+
+// removes the pause with the same scope, target, action, and source as p; returns false if there was no active pause
+func Remove(p Pause) bool {
+	k := p.key()
+	mutex.Lock()
+	p, ok := pauses[k]
+	delete(pauses, k)
+	mutex.Unlock()
+	if !ok || p.Expired() {
+		return false
+	}
+	logger.Infof("Automation resumed for %s %s", p.Scope, p.Target)
+	events.Publish(events.Event{Type: events.TypePause, Door: doorTarget(p), CarID: carTarget(p), Result: CommandResume, Message: p.String()})
+	return true
+}
+
+// returns the active pause of any action that applies to a garage door and car
+func Active(door string, carID int) (Pause, bool) {
+	return ActiveFor(door, carID, "")
+}
+
+// returns the active pause that applies to an action on a garage door triggered by a car, preferring global, then
+// garage door, then car scope; pauses with the same scope are checked in key order, so the same one is always
+// returned, e.g. a pause set by command before a calendar pause; expired pauses are removed as they're found
+func ActiveFor(door string, carID int, action string) (Pause, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	keys := make([]string, 0, len(pauses))
+	for k := range pauses {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var active Pause
+	found := false
+	for _, k := range keys {
+		p := pauses[k]
+		if p.Expired() {
+			logger.Infof("Pause for %s expired, automation resumed", p)
+			delete(pauses, k)
+			continue
+		}
+		if !p.applies(door, carID, action) {
+			continue
+		}
+		if !found || scopePriority[p.Scope] < scopePriority[active.Scope] {
+			active = p
+			found = true
+		}
+	}
+	return active, found
+}
+
+// returns all unexpired pauses
+func List() []Pause {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var list []Pause
+	for k, p := range pauses {
+		if p.Expired() {
+			delete(pauses, k)
+			continue
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// replaces active pauses with a previously saved list, skipping any that have expired
+func Restore(list []Pause) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	pauses = map[string]Pause{}
+	for _, p := range list {
+		if p.Expired() {
+			continue
+		}
+		pauses[p.key()] = p
+		logger.Infof("Restored pause for %s", p)
+	}
+}
+
+// applies a json encoded Command
+func HandleCommand(payload []byte) error {
+	var c Command
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return fmt.Errorf("unable to parse command: %w", err)
+	}
+	return c.Apply()
+}
+
+// applies the command to the active pauses
+func (c Command) Apply() error {
+	p := Pause{Scope: ScopeGlobal, Reason: c.Reason}
+	switch {
+	case c.Door != "" && c.Car != 0:
+		return fmt.Errorf("only one of door or car may be set")
+	case c.Door != "":
+		p.Scope = ScopeDoor
+		p.Target = c.Door
+	case c.Car != 0:
+		p.Scope = ScopeCar
+		p.Target = strconv.Itoa(c.Car)
+	}
+
+	switch c.Command {
+	case CommandPause:
+		if c.Duration != "" {
+			d, err := time.ParseDuration(c.Duration)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %s", c.Duration)
+			}
+			p.Until = time.Now().Add(d)
+		}
+		Set(p)
+	case CommandResume:
+		if !Clear(p.Scope, p.Target) {
+			logger.Infof("No active pause for %s %s", p.Scope, p.Target)
+		}
+	default:
+		return fmt.Errorf("unknown command %s, must be %s or %s", c.Command, CommandPause, CommandResume)
+	}
+	return nil
+}
+
+func doorTarget(p Pause) string {
+	if p.Scope == ScopeDoor {
+		return p.Target
+	}
+	return ""
+}
+
+func carTarget(p Pause) int {
+	if p.Scope == ScopeCar {
+		id, _ := strconv.Atoi(p.Target)
+		return id
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package pause
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Command_PauseAndResume(t *testing.T) {
+	Restore(nil)
+
+	assert.NoError(t, HandleCommand([]byte(`{"command": "pause", "door": "main", "duration": "1h", "reason": "guests"}`)))
+	p, paused := Active("main", 1)
+	assert.True(t, paused)
+	assert.Equal(t, ScopeDoor, p.Scope)
+	assert.Equal(t, "guests", p.Reason)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), p.Until, time.Second)
+
+	_, paused = Active("side", 1)
+	assert.False(t, paused)
+
+	assert.NoError(t, HandleCommand([]byte(`{"command": "resume", "door": "main"}`)))
+	_, paused = Active("main", 1)
+	assert.False(t, paused)
+}
+
+func Test_Command_Invalid(t *testing.T) {
+	assert.Error(t, HandleCommand([]byte(`not json`)))
+	assert.Error(t, HandleCommand([]byte(`{"command": "stop"}`)))
+	assert.Error(t, HandleCommand([]byte(`{"command": "pause", "duration": "soon"}`)))
+	assert.Error(t, HandleCommand([]byte(`{"command": "pause", "door": "main", "car": 1}`)))
+}
+
+func Test_Active_ScopesAndExpiry(t *testing.T) {
+	Restore([]Pause{
+		{Scope: ScopeCar, Target: "2"},
+		{Scope: ScopeGlobal, Until: time.Now().Add(-time.Minute)}, // already expired
+	})
+
+	_, paused := Active("main", 1)
+	assert.False(t, paused)
+	p, paused := Active("main", 2)
+	assert.True(t, paused)
+	assert.Equal(t, ScopeCar, p.Scope)
+
+	Set(Pause{Scope: ScopeGlobal, Until: time.Now().Add(-time.Second)})
+	_, paused = Active("main", 1)
+	assert.False(t, paused)
+	assert.Len(t, List(), 1) // expired global pause removed
+}
+
+func Test_ActiveFor_ActionAndSource(t *testing.T) {
+	Restore(nil)
+	calendar := Pause{Scope: ScopeDoor, Target: "main", Action: "open", Source: "calendar 1"}
+	Set(calendar)
+
+	_, paused := ActiveFor("main", 1, "open")
+	assert.True(t, paused)
+	_, paused = ActiveFor("main", 1, "close")
+	assert.False(t, paused)
+
+	// resuming by command doesn't clear a calendar pause
+	assert.False(t, Clear(ScopeDoor, "main"))
+	_, paused = Active("main", 1)
+	assert.True(t, paused)
+
+	assert.True(t, Remove(calendar))
+	_, paused = Active("main", 1)
+	assert.False(t, paused)
+}
+
+func Test_ActiveFor_SameScope(t *testing.T) {
+	Restore(nil)
+	defer Restore(nil)
+	Set(Pause{Scope: ScopeGlobal, Reason: "calendar event party", Source: "calendar 1"})
+	Set(Pause{Scope: ScopeGlobal, Reason: "guests"})
+	Set(Pause{Scope: ScopeGlobal, Reason: "calendar event trip", Source: "calendar 2"})
+
+	// the pause set by command is returned every time, not whichever the map yields first
+	for i := 0; i < 20; i++ {
+		p, paused := Active("main", 1)
+		assert.True(t, paused)
+		assert.Equal(t, "guests", p.Reason)
+	}
+}
@@ -0,0 +1,244 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/brchri/myq"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const haPauseReason = "paused from home assistant"
+
+type (
+	// device that all home assistant entities are grouped under
+	haDevice struct {
+		Identifiers  []string `json:"identifiers"`
+		Name         string   `json:"name"`
+		Manufacturer string   `json:"manufacturer"`
+		SwVersion    string   `json:"sw_version,omitempty"`
+	}
+
+	// fields shared by all home assistant discovery payloads
+	haEntity struct {
+		Name              string   `json:"name"`
+		UniqueID          string   `json:"unique_id"`
+		StateTopic        string   `json:"state_topic"`
+		AvailabilityTopic string   `json:"availability_topic"`
+		Device            haDevice `json:"device"`
+		Icon              string   `json:"icon,omitempty"`
+	}
+
+	haCover struct {
+		haEntity
+		DeviceClass  string  `json:"device_class"`
+		CommandTopic string  `json:"command_topic"`
+		PayloadOpen  string  `json:"payload_open"`
+		PayloadClose string  `json:"payload_close"`
+		PayloadStop  *string `json:"payload_stop"` // null hides the stop button, which myq doesn't support
+		StateOpen    string  `json:"state_open"`
+		StateOpening string  `json:"state_opening"`
+		StateClosed  string  `json:"state_closed"`
+		StateClosing string  `json:"state_closing"`
+	}
+
+	haSwitch struct {
+		haEntity
+		CommandTopic string `json:"command_topic"`
+		PayloadOn    string `json:"payload_on"`
+		PayloadOff   string `json:"payload_off"`
+	}
+
+	// announces garage doors, car zones, and a pause automation switch to home assistant through mqtt discovery,
+	// and routes commands from home assistant through the same paths as geofence triggered actions
+	Discovery struct {
+		publisher  *Publisher
+		config     *util.ConfigStruct
+		controller *geo.Controller
+		version    string
+		announced  map[string]bool // discovery topics published by the last announcement
+		subscribed map[string]bool // garage door command topics subscribed to
+		mutex      sync.Mutex      // guards config
+	}
+)
+
+func NewDiscovery(publisher *Publisher, config *util.ConfigStruct, controller *geo.Controller, version string) *Discovery {
+	return &Discovery{
+		publisher:  publisher,
+		config:     config,
+		controller: controller,
+		version:    version,
+		announced:  map[string]bool{},
+		subscribed: map[string]bool{},
+	}
+}
+
+// replaces the config whose garage doors are announced, e.g. after the config is reloaded; takes effect on the next
+// Announce and Subscribe
+func (d *Discovery) SetConfig(config *util.ConfigStruct) {
+	d.mutex.Lock()
+	d.config = config
+	d.mutex.Unlock()
+}
+
+func (d *Discovery) currentConfig() *util.ConfigStruct {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.config
+}
+
+// returns the topic to receive home assistant commands for a garage door
+func DoorCommandTopic(prefix string, door string) string {
+	return DoorTopic(prefix, door, "set")
+}
+
+// returns the topic to receive home assistant commands for the pause automation switch
+func PausedCommandTopic(prefix string) string {
+	return PausedTopic(prefix) + "/set"
+}
+
+// publishes retained discovery messages for all entities and removes entities announced previously that no longer
+// exist, e.g. after a garage door is removed from the config; should be called each time the client connects
+func (d *Discovery) Announce() {
+	prefix := d.publisher.prefix
+	announced := map[string]bool{}
+	node := TopicSafe(prefix)
+	device := haDevice{
+		Identifiers:  []string{node},
+		Name:         "Tesla-YouQ",
+		Manufacturer: "brchri",
+		SwVersion:    d.version,
+	}
+	entity := func(name string, uniqueID string, stateTopic string) haEntity {
+		return haEntity{
+			Name:              name,
+			UniqueID:          node + "_" + uniqueID,
+			StateTopic:        stateTopic,
+			AvailabilityTopic: AvailabilityTopic(prefix),
+			Device:            device,
+		}
+	}
+
+	for _, g := range d.currentConfig().GarageDoors {
+		id := TopicSafe(g.Name)
+		announced[d.announce("cover", node, id, haCover{
+			haEntity:     entity(fmt.Sprintf("Garage door %s", g.Name), id, DoorTopic(prefix, g.Name, "state")),
+			DeviceClass:  "garage",
+			CommandTopic: DoorCommandTopic(prefix, g.Name),
+			PayloadOpen:  myq.ActionOpen,
+			PayloadClose: myq.ActionClose,
+			StateOpen:    myq.StateOpen,
+			StateOpening: geo.StateOpening,
+			StateClosed:  myq.StateClosed,
+			StateClosing: geo.StateClosing,
+		})] = true
+
+		for _, c := range g.Cars {
+			id := fmt.Sprintf("car_%d_zone", c.ID)
+			e := entity(fmt.Sprintf("Car %d zone", c.ID), id, CarTopic(prefix, c.ID, "zone"))
+			e.Icon = "mdi:map-marker"
+			announced[d.announce("sensor", node, id, e)] = true
+		}
+	}
+
+	e := entity("Pause automation", "pause", PausedTopic(prefix))
+	e.Icon = "mdi:pause-circle"
+	announced[d.announce("switch", node, "pause", haSwitch{
+		haEntity:     e,
+		CommandTopic: PausedCommandTopic(prefix),
+		PayloadOn:    PausedOn,
+		PayloadOff:   PausedOff,
+	})] = true
+
+	// an empty retained discovery message removes the entity from home assistant
+	for topic := range d.announced {
+		if !announced[topic] {
+			logger.Debugf("Removing home assistant entity announced on %s", topic)
+			d.publisher.Publish(topic, "")
+		}
+	}
+	d.announced = announced
+}
+
+// publishes a discovery message for a single entity and returns its topic
+func (d *Discovery) announce(component string, node string, objectID string, payload interface{}) string {
+	topic := fmt.Sprintf("%s/%s/%s/%s/config", d.currentConfig().Global.HaDiscoveryPrefix, component, node, objectID)
+	b, err := json.Marshal(payload)
+	if err != nil {
+		logger.Warnf("Unable to encode home assistant discovery message for %s %s: %v", component, objectID, err)
+		return topic
+	}
+	d.publisher.Publish(topic, string(b))
+	return topic
+}
+
+// subscribes to command topics for garage doors and the pause automation switch, and unsubscribes from command topics
+// of garage doors that no longer exist
+func (d *Discovery) Subscribe() error {
+	prefix := d.publisher.prefix
+	subscribed := map[string]bool{}
+	for _, g := range d.currentConfig().GarageDoors {
+		garageDoor := g
+		topic := DoorCommandTopic(prefix, garageDoor.Name)
+		logger.Debugf("Subscribing to topic: %s", topic)
+		if token := d.publisher.client.Subscribe(topic, 1, func(client mqtt.Client, message mqtt.Message) {
+			d.handleDoorCommand(garageDoor, string(message.Payload()))
+		}); token.Wait() && token.Error() != nil {
+			return fmt.Errorf("unable to subscribe to topic %s: %w", topic, token.Error())
+		}
+		subscribed[topic] = true
+	}
+	for topic := range d.subscribed {
+		if !subscribed[topic] {
+			logger.Debugf("Unsubscribing from topic: %s", topic)
+			d.publisher.client.Unsubscribe(topic)
+		}
+	}
+	d.subscribed = subscribed
+
+	topic := PausedCommandTopic(prefix)
+	logger.Debugf("Subscribing to topic: %s", topic)
+	if token := d.publisher.client.Subscribe(topic, 1, func(client mqtt.Client, message mqtt.Message) {
+		d.handlePauseCommand(string(message.Payload()))
+	}); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("unable to subscribe to topic %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+// requests an open or close action through the same cooldown path as geofence triggered actions
+func (d *Discovery) handleDoorCommand(garageDoor *util.GarageDoor, payload string) {
+	action := strings.ToLower(payload)
+	if action != myq.ActionOpen && action != myq.ActionClose {
+		logger.Warnf("Received unsupported home assistant command %s for garage door %s", payload, garageDoor.Name)
+		return
+	}
+	logger.Infof("Received home assistant request to %s garage door %s", action, garageDoor.Name)
+	if !d.controller.RequestAction(*d.currentConfig(), garageDoor, action) {
+		logger.Infof("Garage door %s is on cooldown, ignoring home assistant request to %s", garageDoor.Name, action)
+	}
+}
+
+// pauses or resumes automation for all garage doors
+func (d *Discovery) handlePauseCommand(payload string) {
+	var c pause.Command
+	switch strings.ToUpper(payload) {
+	case PausedOn:
+		c = pause.Command{Command: pause.CommandPause, Reason: haPauseReason}
+	case PausedOff:
+		c = pause.Command{Command: pause.CommandResume}
+	default:
+		logger.Warnf("Received unsupported home assistant command %s for pause automation switch", payload)
+		return
+	}
+	if err := c.Apply(); err != nil {
+		logger.Warnf("Unable to apply home assistant pause command: %v", err)
+	}
+}
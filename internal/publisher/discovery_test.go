@@ -0,0 +1,87 @@
+package publisher
+
+import (
+	"encoding/json"
+	"testing"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDiscovery() (*Discovery, *fakeClient, *util.GarageDoor) {
+	p, client, car := newTestPublisher()
+	car.GarageDoor.Cars = []*util.Car{car}
+	config := &util.ConfigStruct{Testing: true, GarageDoors: []*util.GarageDoor{car.GarageDoor}}
+	config.Global.HaDiscoveryPrefix = "homeassistant"
+	return NewDiscovery(p, config, geo.NewMyqController(), "v1.0.0"), client, car.GarageDoor
+}
+
+func Test_Announce(t *testing.T) {
+	d, client, _ := newTestDiscovery()
+
+	d.Announce()
+
+	var cover map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(client.retained["homeassistant/cover/tesla-youq/main_door/config"]), &cover))
+	assert.Equal(t, "tesla-youq_main_door", cover["unique_id"])
+	assert.Equal(t, "garage", cover["device_class"])
+	assert.Equal(t, "tesla-youq/doors/main_door/state", cover["state_topic"])
+	assert.Equal(t, "tesla-youq/doors/main_door/set", cover["command_topic"])
+	assert.Equal(t, "tesla-youq/availability", cover["availability_topic"])
+	assert.Contains(t, cover, "payload_stop")
+	assert.Nil(t, cover["payload_stop"])
+
+	var sensor map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(client.retained["homeassistant/sensor/tesla-youq/car_1_zone/config"]), &sensor))
+	assert.Equal(t, "tesla-youq/cars/1/zone", sensor["state_topic"])
+
+	var pauseSwitch map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(client.retained["homeassistant/switch/tesla-youq/pause/config"]), &pauseSwitch))
+	assert.Equal(t, "tesla-youq/paused", pauseSwitch["state_topic"])
+	assert.Equal(t, "tesla-youq/paused/set", pauseSwitch["command_topic"])
+}
+
+func Test_Announce_RemovesStaleEntities(t *testing.T) {
+	d, client, garageDoor := newTestDiscovery()
+	d.Announce()
+	assert.NotEmpty(t, client.retained["homeassistant/sensor/tesla-youq/car_1_zone/config"])
+
+	// car moved to a different id in the reloaded config
+	garageDoor.Cars = []*util.Car{{ID: 2, GarageDoor: garageDoor}}
+	d.Announce()
+	assert.Empty(t, client.retained["homeassistant/sensor/tesla-youq/car_1_zone/config"])
+	assert.NotEmpty(t, client.retained["homeassistant/sensor/tesla-youq/car_2_zone/config"])
+	assert.NotEmpty(t, client.retained["homeassistant/cover/tesla-youq/main_door/config"])
+}
+
+func Test_handlePauseCommand(t *testing.T) {
+	d, _, _ := newTestDiscovery()
+	defer pause.Restore(nil)
+
+	d.handlePauseCommand("ON")
+	_, paused := pause.Active("main door", 1)
+	assert.True(t, paused)
+
+	d.handlePauseCommand("OFF")
+	_, paused = pause.Active("main door", 1)
+	assert.False(t, paused)
+}
+
+func Test_handleDoorCommand_Cooldown(t *testing.T) {
+	d, _, garageDoor := newTestDiscovery()
+	garageDoor.OpLock = true // simulate cooldown so no action is requested
+	var received []events.Event
+	unsubscribe := events.Subscribe(func(e events.Event) { received = append(received, e) })
+	defer unsubscribe()
+
+	d.handleDoorCommand(garageDoor, "close")
+	d.handleDoorCommand(garageDoor, "stop") // unsupported, ignored
+
+	assert.Len(t, received, 1)
+	assert.Equal(t, events.TypeCooldown, received[0].Type)
+	assert.Equal(t, events.ResultSuppressed, received[0].Result)
+	assert.Equal(t, "close", received[0].Action)
+}
@@ -0,0 +1,209 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	AvailabilityOnline  = "online"
+	AvailabilityOffline = "offline"
+
+	PausedOn  = "ON"
+	PausedOff = "OFF"
+
+	qos = 1
+)
+
+// payload published to the last_action topic of a garage door
+type LastAction struct {
+	Action  string    `json:"action"`
+	Result  string    `json:"result"`
+	CarID   int       `json:"car_id,omitempty"` // 0 if the action was requested manually
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// publishes app state and garage door actions to retained mqtt topics under a prefix, e.g.
+//
+//	<prefix>/availability
+//	<prefix>/paused
+//	<prefix>/doors/<name>/state
+//	<prefix>/doors/<name>/last_action
+//	<prefix>/cars/<id>/zone
+//	<prefix>/cars/<id>/distance
+type Publisher struct {
+	client    mqtt.Client
+	prefix    string
+	cars      []*util.Car
+	published map[string]string // last payload published to each topic, to skip publishing unchanged values
+	mutex     sync.Mutex
+}
+
+var unsafeTopicChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+func New(client mqtt.Client, prefix string, cars []*util.Car) *Publisher {
+	return &Publisher{
+		client:    client,
+		prefix:    prefix,
+		cars:      cars,
+		published: map[string]string{},
+	}
+}
+
+// returns the availability topic, which should also be set as the mqtt client's last will and testament
+func AvailabilityTopic(prefix string) string {
+	return prefix + "/availability"
+}
+
+// returns the topic reporting whether automation is paused globally
+func PausedTopic(prefix string) string {
+	return prefix + "/paused"
+}
+
+// returns the topic for a garage door attribute, e.g. state
+func DoorTopic(prefix string, door string, attribute string) string {
+	return fmt.Sprintf("%s/doors/%s/%s", prefix, TopicSafe(door), attribute)
+}
+
+// returns the topic for a car attribute, e.g. zone
+func CarTopic(prefix string, carID int, attribute string) string {
+	return fmt.Sprintf("%s/cars/%d/%s", prefix, carID, attribute)
+}
+
+// replaces characters that aren't safe to use in an mqtt topic level
+func TopicSafe(s string) string {
+	return unsafeTopicChars.ReplaceAllString(s, "_")
+}
+
+// subscribes to app events and publishes state changes as they occur
+func (p *Publisher) Start() {
+	events.Subscribe(p.handleEvent)
+}
+
+// publishes availability and the current state of all cars; should be called each time the client connects
+// since retained values may have been cleared by the broker
+func (p *Publisher) PublishAll() {
+	p.mutex.Lock()
+	p.published = map[string]string{}
+	p.mutex.Unlock()
+
+	p.Publish(AvailabilityTopic(p.prefix), AvailabilityOnline)
+	p.publishPaused()
+	for _, c := range p.currentCars() {
+		p.publishCar(c)
+	}
+}
+
+// replaces the cars whose state is published, e.g. after the config is reloaded
+func (p *Publisher) SetCars(cars []*util.Car) {
+	p.mutex.Lock()
+	p.cars = cars
+	p.mutex.Unlock()
+	for _, c := range cars {
+		p.publishCar(c)
+	}
+}
+
+func (p *Publisher) currentCars() []*util.Car {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.cars
+}
+
+// publishes a retained payload to topic if it differs from the last payload published to it
+func (p *Publisher) Publish(topic string, payload string) {
+	p.mutex.Lock()
+	if last, ok := p.published[topic]; ok && last == payload {
+		p.mutex.Unlock()
+		return
+	}
+	p.published[topic] = payload
+	p.mutex.Unlock()
+
+	logger.Debugf("Publishing %s to topic %s", payload, topic)
+	token := p.client.Publish(topic, qos, true, payload)
+	// don't block the publishing goroutine waiting for the broker; log failures in the background
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logger.Warnf("Unable to publish to topic %s: %v", topic, token.Error())
+			p.mutex.Lock()
+			delete(p.published, topic)
+			p.mutex.Unlock()
+		}
+	}()
+}
+
+func (p *Publisher) handleEvent(e events.Event) {
+	switch e.Type {
+	case events.TypeEvaluation:
+		if c := p.findCar(e.Door, e.CarID); c != nil {
+			p.publishCar(c)
+		}
+		// pauses expire without an event, so refresh the paused state as cars are evaluated
+		p.publishPaused()
+	case events.TypePause:
+		p.publishPaused()
+	case events.TypeDoorState:
+		p.Publish(DoorTopic(p.prefix, e.Door, "state"), e.Result)
+	case events.TypeOpenerResponse:
+		payload, err := json.Marshal(LastAction{Action: e.Action, Result: e.Result, CarID: e.CarID, Message: e.Message, Time: e.Time})
+		if err != nil {
+			logger.Warnf("Unable to encode last action for garage door %s: %v", e.Door, err)
+			return
+		}
+		p.Publish(DoorTopic(p.prefix, e.Door, "last_action"), string(payload))
+	}
+}
+
+// publishes a car's zone and, for circular geofences, distance from the garage door
+func (p *Publisher) publishCar(c *util.Car) {
+	p.Publish(CarTopic(p.prefix, c.ID, "zone"), geo.GetZoneStatus(c).Zone)
+	state := c.Snapshot()
+	if state.GarageDoor.GeofenceType == util.CircularGeofenceType && state.CurrentLocation.IsPointDefined() {
+		p.Publish(CarTopic(p.prefix, c.ID, "distance"), strconv.FormatFloat(state.CurDistance, 'f', 3, 64))
+	}
+}
+
+// publishes whether a global pause of all actions set by command is active; pauses managed by a calendar aren't
+// reflected since they can't be resumed by command
+func (p *Publisher) publishPaused() {
+	paused := PausedOff
+	for _, pp := range pause.List() {
+		if pp.Scope == pause.ScopeGlobal && pp.Action == "" && pp.Source == "" {
+			paused = PausedOn
+			break
+		}
+	}
+	p.Publish(PausedTopic(p.prefix), paused)
+}
+
+func (p *Publisher) findCar(door string, carID int) *util.Car {
+	for _, c := range p.currentCars() {
+		if c.ID == carID && c.Snapshot().GarageDoor.Name == door {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package publisher
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	util "github.com/brchri/tesla-youq/internal/util"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+// records retained publishes; other client methods are not used by the publisher
+type fakeClient struct {
+	mqtt.Client
+	published []string
+	retained  map[string]string
+	mutex     sync.Mutex
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.published = append(c.published, topic)
+	if retained {
+		c.retained[topic] = payload.(string)
+	}
+	return &mqtt.DummyToken{}
+}
+
+func newTestPublisher() (*Publisher, *fakeClient, *util.Car) {
+	door := &util.GarageDoor{
+		Name:         "main door",
+		GeofenceType: util.CircularGeofenceType,
+		CircularGeofence: &util.CircularGeofence{
+			Center:        util.Point{Lat: 46.19290, Lng: -123.79185},
+			CloseDistance: 0.013,
+			OpenDistance:  0.04,
+		},
+	}
+	car := &util.Car{ID: 1, GarageDoor: door}
+	client := &fakeClient{retained: map[string]string{}}
+	return New(client, "tesla-youq", []*util.Car{car}), client, car
+}
+
+func Test_PublishAll(t *testing.T) {
+	p, client, _ := newTestPublisher()
+
+	p.PublishAll()
+
+	assert.Equal(t, AvailabilityOnline, client.retained["tesla-youq/availability"])
+	assert.Equal(t, "unknown", client.retained["tesla-youq/cars/1/zone"])
+	assert.NotContains(t, client.retained, "tesla-youq/cars/1/distance") // location not yet received
+}
+
+func Test_Publish_SkipsUnchanged(t *testing.T) {
+	p, client, _ := newTestPublisher()
+
+	p.Publish("tesla-youq/doors/main/state", "closed")
+	p.Publish("tesla-youq/doors/main/state", "closed")
+	p.Publish("tesla-youq/doors/main/state", "open")
+
+	assert.Len(t, client.published, 2)
+	assert.Equal(t, "open", client.retained["tesla-youq/doors/main/state"])
+}
+
+func Test_handleEvent(t *testing.T) {
+	p, client, car := newTestPublisher()
+	car.CurrentLocation = util.Point{Lat: 46.19292, Lng: -123.79437}
+	car.CurDistance = 1.23456
+
+	p.handleEvent(events.Event{Type: events.TypeDoorState, Door: "main door", Result: "closing"})
+	p.handleEvent(events.Event{Type: events.TypeOpenerResponse, Door: "main door", CarID: 1, Action: "close", Result: events.ResultSuccess})
+	p.handleEvent(events.Event{Type: events.TypeEvaluation, Door: "main door", CarID: 1})
+
+	assert.Equal(t, "closing", client.retained["tesla-youq/doors/main_door/state"])
+	var lastAction LastAction
+	assert.NoError(t, json.Unmarshal([]byte(client.retained["tesla-youq/doors/main_door/last_action"]), &lastAction))
+	assert.Equal(t, "close", lastAction.Action)
+	assert.Equal(t, events.ResultSuccess, lastAction.Result)
+	assert.Equal(t, 1, lastAction.CarID)
+	assert.Equal(t, "away", client.retained["tesla-youq/cars/1/zone"])
+	assert.Equal(t, "1.235", client.retained["tesla-youq/cars/1/distance"])
+}
@@ -0,0 +1,244 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/brchri/tesla-youq/internal/events"
+	geo "github.com/brchri/tesla-youq/internal/geo"
+	pause "github.com/brchri/tesla-youq/internal/pause"
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+const flushInterval = 5 * time.Second // minimum time between writes of the state file
+
+type (
+	// persisted runtime state of a car, matched to configured cars by garage door name and car id
+	CarState struct {
+		ID                 int        `json:"id"`
+		Door               string     `json:"door"`
+		CurrentLocation    util.Point `json:"current_location"`
+		CurDistance        float64    `json:"cur_distance"`
+		PrevGeofence       string     `json:"prev_geofence"`
+		CurGeofence        string     `json:"cur_geofence"`
+		InsidePolyOpenGeo  bool       `json:"inside_poly_open_geo"`
+		InsidePolyCloseGeo bool       `json:"inside_poly_close_geo"`
+		UpdatedAt          time.Time  `json:"updated_at"`
+	}
+
+	// persisted runtime state of a garage door
+	DoorState struct {
+		Name          string    `json:"name"`
+		CooldownUntil time.Time `json:"cooldown_until"`
+	}
+
+	// contents of the state file
+	State struct {
+		SavedAt time.Time     `json:"saved_at"`
+		Cars    []CarState    `json:"cars"`
+		Doors   []DoorState   `json:"doors"`
+		Pauses  []pause.Pause `json:"pauses"`
+	}
+
+	// persists car and garage door state to a file whenever it changes
+	Store struct {
+		path  string
+		cars  []*util.Car
+		doors []*util.GarageDoor
+		dirty chan struct{}
+		done  chan struct{}
+		wg    sync.WaitGroup
+		unsub func()
+		mutex sync.Mutex
+	}
+)
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// reads the state file at path, discarding car entries that were last updated more than maxAge ago;
+// returns an empty state if the file doesn't exist
+func Load(path string, maxAge time.Duration) (*State, error) {
+	s := &State{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read state file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("unable to parse state file %s: %w", path, err)
+	}
+
+	if maxAge > 0 {
+		var cars []CarState
+		for _, c := range s.Cars {
+			if time.Since(c.UpdatedAt) > maxAge {
+				logger.Debugf("Discarding stale state for car %d, last updated %s", c.ID, c.UpdatedAt.Format("01/02/2006 15:04:05"))
+				continue
+			}
+			cars = append(cars, c)
+		}
+		s.Cars = cars
+	}
+	return s, nil
+}
+
+// restores persisted state onto the configured cars, garage doors, and active pauses; returns the
+// garage doors that have an unexpired cooldown so the caller can resume them
+func (s *State) Apply(cars []*util.Car, doors []*util.GarageDoor) map[*util.GarageDoor]time.Time {
+	pause.Restore(s.Pauses)
+
+	for _, c := range cars {
+		for _, cs := range s.Cars {
+			c.Lock()
+			if cs.ID != c.ID || cs.Door != c.GarageDoor.Name {
+				c.Unlock()
+				continue
+			}
+			c.CurrentLocation = cs.CurrentLocation
+			c.CurDistance = cs.CurDistance
+			c.PrevGeofence = cs.PrevGeofence
+			c.CurGeofence = cs.CurGeofence
+			c.InsidePolyOpenGeo = cs.InsidePolyOpenGeo
+			c.InsidePolyCloseGeo = cs.InsidePolyCloseGeo
+			c.LastUpdate = cs.UpdatedAt
+			c.Unlock()
+			logger.Infof("Restored state for car %d, last updated %s", c.ID, cs.UpdatedAt.Format("01/02/2006 15:04:05"))
+		}
+	}
+
+	cooldowns := map[*util.GarageDoor]time.Time{}
+	for _, d := range doors {
+		for _, ds := range s.Doors {
+			if ds.Name == d.Name && ds.CooldownUntil.After(time.Now()) {
+				cooldowns[d] = ds.CooldownUntil
+			}
+		}
+	}
+	return cooldowns
+}
+
+// returns a store that writes the state of cars and doors to path
+func NewStore(path string, cars []*util.Car, doors []*util.GarageDoor) *Store {
+	return &Store{
+		path:  path,
+		cars:  cars,
+		doors: doors,
+		dirty: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// replaces the cars and doors whose state is saved, e.g. after the config is reloaded
+func (st *Store) SetTargets(cars []*util.Car, doors []*util.GarageDoor) {
+	st.mutex.Lock()
+	st.cars = cars
+	st.doors = doors
+	st.mutex.Unlock()
+	st.MarkDirty()
+}
+
+// saves state in the background whenever an evaluation, action, cooldown, or pause event is published
+func (st *Store) Watch() {
+	st.wg.Add(1)
+	go st.flushLoop()
+	st.unsub = events.Subscribe(func(e events.Event) {
+		if e.Type == events.TypeEvaluation || e.Type == events.TypeActionRequested || e.Type == events.TypeCooldown || e.Type == events.TypePause {
+			st.MarkDirty()
+		}
+	})
+}
+
+// flags the state as changed so it's written on the next flush
+func (st *Store) MarkDirty() {
+	select {
+	case st.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// writes state at most once per flushInterval while it's changing
+func (st *Store) flushLoop() {
+	defer st.wg.Done()
+	for {
+		select {
+		case <-st.dirty:
+			if err := st.Save(); err != nil {
+				logger.Warnf("Unable to save state: %v", err)
+			}
+			select {
+			case <-time.After(flushInterval):
+			case <-st.done:
+				return
+			}
+		case <-st.done:
+			return
+		}
+	}
+}
+
+// writes the current state of all cars and doors to the state file, replacing it atomically
+func (st *Store) Save() error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	s := State{SavedAt: time.Now(), Pauses: pause.List()}
+	for _, c := range st.cars {
+		cs := c.Snapshot()
+		s.Cars = append(s.Cars, CarState{
+			ID:                 c.ID,
+			Door:               cs.GarageDoor.Name,
+			CurrentLocation:    cs.CurrentLocation,
+			CurDistance:        cs.CurDistance,
+			PrevGeofence:       cs.PrevGeofence,
+			CurGeofence:        cs.CurGeofence,
+			InsidePolyOpenGeo:  cs.InsidePolyOpenGeo,
+			InsidePolyCloseGeo: cs.InsidePolyCloseGeo,
+			UpdatedAt:          cs.LastUpdate,
+		})
+	}
+	for _, d := range st.doors {
+		_, until := geo.GetCooldown(d)
+		s.Doors = append(s.Doors, DoorState{Name: d.Name, CooldownUntil: until})
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+// stops watching for changes and writes the final state
+func (st *Store) Close() error {
+	if st.unsub != nil {
+		st.unsub()
+		close(st.done)
+		st.wg.Wait()
+	}
+	return st.Save()
+}
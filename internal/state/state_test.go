@@ -0,0 +1,47 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGarage() (*util.GarageDoor, []*util.Car) {
+	door := &util.GarageDoor{Name: "main"}
+	cars := []*util.Car{{ID: 1, GarageDoor: door}, {ID: 2, GarageDoor: door}}
+	door.Cars = cars
+	return door, cars
+}
+
+func Test_SaveAndApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	door, cars := newGarage()
+	cars[0].CurrentLocation = util.Point{Lat: 46.19, Lng: -123.79}
+	cars[0].CurDistance = 0.5
+	cars[0].InsidePolyOpenGeo = true
+	cars[0].LastUpdate = time.Now()
+	cars[1].CurGeofence = "home"
+	cars[1].LastUpdate = time.Now().Add(-2 * time.Hour) // stale
+	door.CooldownUntil = time.Now().Add(time.Minute)
+	assert.NoError(t, NewStore(path, cars, []*util.GarageDoor{door}).Save())
+
+	s, err := Load(path, time.Hour)
+	assert.NoError(t, err)
+
+	restoredDoor, restoredCars := newGarage()
+	cooldowns := s.Apply(restoredCars, []*util.GarageDoor{restoredDoor})
+	assert.Equal(t, cars[0].CurrentLocation, restoredCars[0].CurrentLocation)
+	assert.Equal(t, 0.5, restoredCars[0].CurDistance)
+	assert.True(t, restoredCars[0].InsidePolyOpenGeo)
+	assert.Equal(t, "", restoredCars[1].CurGeofence) // discarded as stale
+	assert.WithinDuration(t, door.CooldownUntil, cooldowns[restoredDoor], time.Millisecond)
+}
+
+func Test_Load_MissingFile(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "missing.json"), time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, s.Cars)
+}
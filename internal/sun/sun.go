@@ -0,0 +1,58 @@
+package sun
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5 // julian date of 1970-01-01 00:00 UTC
+	julian2000      = 2451545.0 // julian date of 2000-01-01 12:00 UTC
+	obliquity       = 23.4397   // degrees, axial tilt of the earth
+	horizon         = -0.833    // degrees, sun altitude at sunrise and sunset accounting for refraction and the sun's radius
+)
+
+// returns sunrise and sunset on the calendar day of t, in t's location, for a point at lat and lng (degrees, east positive);
+// ok is false if the sun doesn't rise or set that day, e.g. polar day or night
+// calculated locally with the sunrise equation, accurate to within a couple of minutes outside polar regions
+func Times(t time.Time, lat float64, lng float64) (sunrise time.Time, sunset time.Time, ok bool) {
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, t.Location())
+	n := math.Round(julianDate(noon) - julian2000 + lng/360) // day number whose mean solar noon at lng falls on t's day
+
+	meanNoon := n - lng/360
+	m := normalize(357.5291 + 0.98560028*meanNoon) // solar mean anomaly
+	c := 1.9148*sin(m) + 0.0200*sin(2*m) + 0.0003*sin(3*m)
+	lambda := normalize(m + c + 180 + 102.9372) // ecliptic longitude
+	transit := julian2000 + meanNoon + 0.0053*sin(m) - 0.0069*sin(2*lambda)
+
+	declination := math.Asin(sin(lambda) * sin(obliquity))
+	cosHourAngle := (sin(horizon) - sin(lat)*math.Sin(declination)) / (cos(lat) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	sunrise = fromJulianDate(transit - hourAngle/360).In(t.Location())
+	sunset = fromJulianDate(transit + hourAngle/360).In(t.Location())
+	return sunrise, sunset, true
+}
+
+func julianDate(t time.Time) float64 {
+	return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+func fromJulianDate(j float64) time.Time {
+	return time.Unix(0, int64((j-julianUnixEpoch)*86400*float64(time.Second))).Round(time.Second)
+}
+
+func normalize(degrees float64) float64 {
+	return math.Mod(math.Mod(degrees, 360)+360, 360)
+}
+
+func sin(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cos(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
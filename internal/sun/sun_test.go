@@ -0,0 +1,27 @@
+package sun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Times(t *testing.T) {
+	bst := time.FixedZone("BST", 60*60)
+	sunrise, sunset, ok := Times(time.Date(2023, 6, 21, 0, 0, 0, 0, bst), 51.5074, -0.1278) // london
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Date(2023, 6, 21, 4, 43, 0, 0, bst), sunrise, 2*time.Minute)
+	assert.WithinDuration(t, time.Date(2023, 6, 21, 21, 21, 0, 0, bst), sunset, 2*time.Minute)
+
+	aest := time.FixedZone("AEST", 10*60*60)
+	sunrise, sunset, ok = Times(time.Date(2023, 6, 21, 18, 0, 0, 0, aest), -33.8688, 151.2093) // sydney
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Date(2023, 6, 21, 7, 0, 0, 0, aest), sunrise, 2*time.Minute)
+	assert.WithinDuration(t, time.Date(2023, 6, 21, 16, 54, 0, 0, aest), sunset, 2*time.Minute)
+}
+
+func Test_Times_PolarDay(t *testing.T) {
+	_, _, ok := Times(time.Date(2023, 6, 21, 0, 0, 0, 0, time.UTC), 80, 0)
+	assert.False(t, ok)
+}
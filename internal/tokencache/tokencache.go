@@ -0,0 +1,258 @@
+package tokencache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	util "github.com/brchri/tesla-youq/internal/util"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	version       = 2 // version 1 derived the aes key from a sha256 hash of the token cache key, without a salt
+	legacyVersion = 1
+
+	// scrypt parameters for deriving the aes-256 key from the token cache key, so weak keys are expensive to guess
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+	saltSize = 16
+)
+
+var (
+	ErrNoKey    = errors.New("token cache is encrypted but no token cache key is defined")
+	ErrWrongKey = errors.New("unable to decrypt token cache, check that the token cache key hasn't changed")
+
+	additionalData = []byte("tesla-youq token cache") // authenticated with each encrypted cache so other gcm ciphertexts aren't accepted
+)
+
+type (
+	// caches session tokens of opener backends by name, e.g. myq, encrypted with aes-gcm if a key is defined
+	Cache struct {
+		path       string
+		passphrase string // token cache key; tokens are stored in plaintext if empty
+		salt       []byte // salt key was derived with; generated when first encrypted
+		key        []byte // aes-256 key derived from passphrase and salt; nil until derived
+		tokens     map[string]string
+	}
+
+	// contents of the cache file; Salt and Data are set if encrypted, and Tokens otherwise
+	file struct {
+		Version int               `json:"version"`
+		Salt    []byte            `json:"salt,omitempty"` // scrypt salt of the aes key
+		Data    []byte            `json:"data,omitempty"` // nonce followed by the sealed json encoded tokens
+		Tokens  map[string]string `json:"tokens,omitempty"`
+	}
+)
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// returns an empty token cache that replaces the file at path when a token is set, encrypted with key if not empty;
+// without a key, an encrypted file is never replaced
+func New(path string, key string) *Cache {
+	return &Cache{path: path, passphrase: key, tokens: map[string]string{}}
+}
+
+// opens the token cache at path, encrypting it with key if not empty; a missing file is an empty cache, and a
+// plaintext token cached before multiple tokens were supported is read as the token for legacy and rewritten in the
+// current format, encrypted if a key is defined, as is a cache encrypted by version 1
+func Open(path string, key string, legacy string) (*Cache, error) {
+	c := New(path, key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read token cache %s: %w", path, err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil || f.Version == 0 {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			logger.Infof("Migrating plaintext token cache %s", path)
+			c.tokens[legacy] = token
+			if err := c.write(); err != nil {
+				return nil, fmt.Errorf("unable to migrate token cache %s: %w", path, err)
+			}
+		}
+		return c, nil
+	}
+	if f.Version > version {
+		return nil, fmt.Errorf("token cache %s was written by a newer version (%d)", path, f.Version)
+	}
+
+	if f.Data == nil {
+		for name, token := range f.Tokens {
+			c.tokens[name] = token
+		}
+		if c.passphrase != "" {
+			logger.Infof("Encrypting plaintext token cache %s", path)
+			if err := c.write(); err != nil {
+				return nil, fmt.Errorf("unable to encrypt token cache %s: %w", path, err)
+			}
+		}
+		return c, nil
+	}
+	if c.passphrase == "" {
+		return nil, ErrNoKey
+	}
+	if f.Version == legacyVersion {
+		sum := sha256.Sum256([]byte(c.passphrase))
+		c.key = sum[:]
+	} else if err := c.deriveKey(f.Salt); err != nil {
+		return nil, err
+	}
+	plaintext, err := c.open(f.Data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plaintext, &c.tokens); err != nil {
+		return nil, fmt.Errorf("invalid token cache %s: %w", path, err)
+	}
+	if f.Version == legacyVersion {
+		logger.Infof("Re-encrypting token cache %s with a salted key", path)
+		c.salt, c.key = nil, nil
+		if err := c.write(); err != nil {
+			return nil, fmt.Errorf("unable to re-encrypt token cache %s: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+// derives the aes key from the passphrase with scrypt and a salt, which must be saltSize random bytes
+func (c *Cache) deriveKey(salt []byte) error {
+	if len(salt) != saltSize {
+		return fmt.Errorf("invalid token cache salt")
+	}
+	key, err := scrypt.Key([]byte(c.passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return err
+	}
+	c.salt, c.key = salt, key
+	return nil
+}
+
+// returns the cached token for a backend, or an empty string if none is cached
+func (c *Cache) Get(name string) string {
+	return c.tokens[name]
+}
+
+// caches the token for a backend and writes the cache file
+func (c *Cache) Set(name string, token string) error {
+	c.tokens[name] = token
+	return c.write()
+}
+
+// writes the cache to a temp file readable only by the current user and moves it into place, so a partially
+// written cache is never read and existing files with broader permissions are replaced
+func (c *Cache) write() error {
+	f := file{Version: version}
+	if c.passphrase == "" {
+		// the key may have been removed from the config by mistake, so an encrypted cache isn't downgraded to plaintext
+		if c.encryptedOnDisk() {
+			return ErrNoKey
+		}
+		f.Tokens = c.tokens
+	} else {
+		if c.key == nil {
+			salt := make([]byte, saltSize)
+			if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+				return err
+			}
+			if err := c.deriveKey(salt); err != nil {
+				return err
+			}
+		}
+		plaintext, err := json.Marshal(c.tokens)
+		if err != nil {
+			return err
+		}
+		if f.Data, err = c.seal(plaintext); err != nil {
+			return err
+		}
+		f.Salt = c.salt
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// returns true if the file at the cache's path is an encrypted cache
+func (c *Cache) encryptedOnDisk() bool {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return false
+	}
+	var f file
+	return json.Unmarshal(data, &f) == nil && f.Data != nil
+}
+
+// encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func (c *Cache) seal(plaintext []byte) ([]byte, error) {
+	gcm, err := c.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// decrypts data sealed by seal
+func (c *Cache) open(data []byte) ([]byte, error) {
+	gcm, err := c.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+func (c *Cache) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,97 @@
+package tokencache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_cache.txt")
+	c, err := Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "", c.Get("myq"))
+	assert.NoError(t, c.Set("myq", "my-token"))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, _ := os.ReadFile(path)
+	assert.NotContains(t, string(data), "my-token")
+
+	c, err = Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-token", c.Get("myq"))
+
+	_, err = Open(path, "other", "myq")
+	assert.ErrorIs(t, err, ErrWrongKey)
+	_, err = Open(path, "", "myq")
+	assert.ErrorIs(t, err, ErrNoKey)
+}
+
+func Test_MigratePlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_cache.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("legacy-token"), 0644))
+
+	// without a key, the legacy token is rewritten in the current format with restricted permissions
+	c, err := Open(path, "", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-token", c.Get("myq"))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// defining a key encrypts the existing tokens
+	c, err = Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-token", c.Get("myq"))
+	data, _ := os.ReadFile(path)
+	assert.NotContains(t, string(data), "legacy-token")
+
+	c, err = Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-token", c.Get("myq"))
+}
+
+func Test_MigrateUnsalted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_cache.txt")
+	// a cache encrypted by version 1, with a key hashed from the passphrase without a salt
+	sum := sha256.Sum256([]byte("secret"))
+	legacy := &Cache{path: path, key: sum[:], tokens: map[string]string{"myq": "my-token"}}
+	plaintext, _ := json.Marshal(legacy.tokens)
+	sealed, err := legacy.seal(plaintext)
+	assert.NoError(t, err)
+	data, _ := json.Marshal(file{Version: legacyVersion, Data: sealed})
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+
+	c, err := Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-token", c.Get("myq"))
+
+	// the cache is rewritten with a salted key
+	var f file
+	data, _ = os.ReadFile(path)
+	assert.NoError(t, json.Unmarshal(data, &f))
+	assert.Equal(t, version, f.Version)
+	assert.Len(t, f.Salt, saltSize)
+	c, err = Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-token", c.Get("myq"))
+}
+
+func Test_NoKeyKeepsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_cache.txt")
+	assert.NoError(t, New(path, "secret").Set("myq", "my-token"))
+	before, _ := os.ReadFile(path)
+
+	// an encrypted cache isn't replaced with a plaintext one when the key is missing
+	assert.ErrorIs(t, New(path, "").Set("myq", "new-token"), ErrNoKey)
+	after, _ := os.ReadFile(path)
+	assert.Equal(t, before, after)
+	c, err := Open(path, "secret", "myq")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-token", c.Get("myq"))
+}
@@ -0,0 +1,40 @@
+package util
+
+import "time"
+
+// runtime state of a car at a point in time, as returned by Car.Snapshot
+type CarState struct {
+	GarageDoor         *GarageDoor
+	CurrentLocation    Point
+	CurDistance        float64
+	PrevGeofence       string
+	CurGeofence        string
+	InsidePolyOpenGeo  bool
+	InsidePolyCloseGeo bool
+	LastUpdate         time.Time
+}
+
+// locks the car's runtime state; the message router, geofence checks, and config reloads update it concurrently
+func (c *Car) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *Car) Unlock() {
+	c.mutex.Unlock()
+}
+
+// returns a copy of the car's runtime state, for reading it from other goroutines, e.g. to report or persist it
+func (c *Car) Snapshot() CarState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CarState{
+		GarageDoor:         c.GarageDoor,
+		CurrentLocation:    c.CurrentLocation,
+		CurDistance:        c.CurDistance,
+		PrevGeofence:       c.PrevGeofence,
+		CurGeofence:        c.CurGeofence,
+		InsidePolyOpenGeo:  c.InsidePolyOpenGeo,
+		InsidePolyCloseGeo: c.InsidePolyCloseGeo,
+		LastUpdate:         c.LastUpdate,
+	}
+}
@@ -2,10 +2,14 @@ package util
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -60,43 +64,108 @@ type (
 	}
 
 	Car struct {
-		ID                 int         `yaml:"teslamate_car_id"` // mqtt identifier for vehicle
-		GarageDoor         *GarageDoor // bidirectional pointer to GarageDoor containing car
-		CurrentLocation    Point       // current vehicle location
-		LocationUpdate     chan Point  // channel to receive location updates
-		CurDistance        float64     // current distance from garagedoor location
-		PrevGeofence       string      // geofence previously ascribed to car
-		CurGeofence        string      // updated geofence ascribed to car when published to mqtt
-		InsidePolyOpenGeo  bool        // indicates if car is currently inside the polygon_open_geofence
-		InsidePolyCloseGeo bool        // indicates if car is currently inside the polygon_close_geofence
+		ID                 int              `yaml:"teslamate_car_id"` // mqtt identifier for vehicle
+		LocationUpdate     *LocationMailbox // latest location updates waiting for a geofence check
+		mutex              sync.Mutex       // guards the fields below once the car is running; see Lock and Snapshot
+		GarageDoor         *GarageDoor      // bidirectional pointer to GarageDoor containing car
+		CurrentLocation    Point            // current vehicle location
+		CurDistance        float64          // current distance from garagedoor location
+		PrevGeofence       string           // geofence previously ascribed to car
+		CurGeofence        string           // updated geofence ascribed to car when published to mqtt
+		InsidePolyOpenGeo  bool             // indicates if car is currently inside the polygon_open_geofence
+		InsidePolyCloseGeo bool             // indicates if car is currently inside the polygon_close_geofence
+		LastUpdate         time.Time        // time the last location or geofence update was received for the car
 	}
 
 	// defines a garage door with one unique geofence type: circular, teslamate, or polygon
 	// only one geofence type may be defined per garage door
 	// if more than one defined, priority will be polygon > circular > teslamate
 	GarageDoor struct {
+		Name              string             `yaml:"name"` // friendly name used in logs and metrics; defaults to myq_serial
 		CircularGeofence  *CircularGeofence  `yaml:"circular_geofence"`
 		TeslamateGeofence *TeslamateGeofence `yaml:"teslamate_geofence"`
 		PolygonGeofence   *PolygonGeofence   `yaml:"polygon_geofence"`
 		MyQSerial         string             `yaml:"myq_serial"`
-		Cars              []*Car             `yaml:"cars"` // cars housed within this garage
-		OpLock            bool               // controls if garagedoor has been operated recently to prevent flapping
+		Cars              []*Car             `yaml:"cars"`                    // cars housed within this garage
+		TransitionTimeout int                `yaml:"transition_timeout"`      // seconds to wait for the door to reach the requested state before reporting a timeout
+		PollInterval      int                `yaml:"poll_interval"`           // seconds between door state checks while waiting for the door to reach the requested state
+		UnexpectedState   string             `yaml:"unexpected_state_policy"` // follow-up when the door settles in an unexpected state, e.g. reversed due to obstruction; one of notify, retry, give_up
+		ConfirmClose      bool               `yaml:"confirm_close"`           // ask for confirmation through notifications before closing the door on a geofence trigger
+		ConfirmTimeout    int                `yaml:"confirm_timeout"`         // seconds to wait for a close confirmation before applying confirm_default
+		ConfirmDefault    string             `yaml:"confirm_default"`         // what to do if a close confirmation times out; one of close, cancel
+		QuietHours        []QuietHours       `yaml:"quiet_hours"`             // windows during which geofence triggered actions are suppressed
+		CloseIfOpenAt     string             `yaml:"close_if_open_at"`        // time of day to close the door if it's left open, as HH:MM or relative to the sun, e.g. sunset+30m
+		OpLock            bool               // controls if garagedoor has been operated recently to prevent flapping; guarded by the geo package
+		CooldownUntil     time.Time          // time the current cooldown expires and OpLock is released; guarded by the geo package
 		GeofenceType      string             //indicates whether garage door uses teslamate's geofence or not (checked during runtime)
 	}
 
+	// defines a notification sink and which events are sent to it
+	Notification struct {
+		Type    string   `yaml:"type"`    // one of ntfy, gotify, pushover, telegram, webhook
+		Url     string   `yaml:"url"`     // ntfy topic url, gotify server url, or webhook url; optional for pushover and telegram
+		Token   string   `yaml:"token"`   // ntfy access token, gotify app token, pushover app token, telegram bot token, or webhook bearer token
+		User    string   `yaml:"user"`    // pushover user or group key
+		ChatID  string   `yaml:"chat_id"` // telegram chat id
+		Events  []string `yaml:"events"`  // events to send: success, failure, timeout, suppressed, attention; sends all if empty
+		Title   string   `yaml:"title"`   // text/template for the notification title
+		Message string   `yaml:"message"` // text/template for the notification message
+	}
+
+	// ical calendar whose events pause automation while they're in progress
+	Calendar struct {
+		Source  string         `yaml:"source"`  // path to a local .ics file or an http(s) url
+		Refresh int            `yaml:"refresh"` // minutes between reloading the calendar
+		Rules   []CalendarRule `yaml:"rules"`   // events that pause automation and what they pause; every event pauses all automation if empty
+	}
+
+	// pauses automation while a calendar event matching the rule is in progress
+	CalendarRule struct {
+		Match  string `yaml:"match"`  // case insensitive text to find in the event summary; matches every event if empty
+		Door   string `yaml:"door"`   // garage door name to pause; pauses all garage doors if neither door nor car is set
+		Car    int    `yaml:"car"`    // teslamate car id to pause
+		Action string `yaml:"action"` // open or close; pauses both if empty
+	}
+
 	ConfigStruct struct {
 		Global struct {
-			MqttHost          string `yaml:"mqtt_host"`
-			MqttPort          int    `yaml:"mqtt_port"`
-			MqttClientID      string `yaml:"mqtt_client_id"`
-			MqttUser          string `yaml:"mqtt_user"`
-			MqttPass          string `yaml:"mqtt_pass"`
-			MqttUseTls        bool   `yaml:"mqtt_use_tls"`
-			MqttSkipTlsVerify bool   `yaml:"mqtt_skip_tls_verify"`
-			OpCooldown        int    `yaml:"cooldown"`
-			MyQEmail          string `yaml:"myq_email"`
-			MyQPass           string `yaml:"myq_pass"`
-			CacheTokenFile    string `yaml:"cache_token_file"`
+			MqttHost            string         `yaml:"mqtt_host"`
+			MqttPort            int            `yaml:"mqtt_port"`
+			MqttClientID        string         `yaml:"mqtt_client_id"`
+			MqttUser            string         `yaml:"mqtt_user"`
+			MqttPass            string         `yaml:"mqtt_pass"`
+			MqttUseTls          bool           `yaml:"mqtt_use_tls"`
+			MqttSkipTlsVerify   bool           `yaml:"mqtt_skip_tls_verify"`
+			MqttCaFile          string         `yaml:"mqtt_ca_file"`          // pem encoded ca bundle to verify the broker with instead of the system roots
+			MqttClientCertFile  string         `yaml:"mqtt_client_cert_file"` // pem encoded client certificate for mutual tls
+			MqttClientKeyFile   string         `yaml:"mqtt_client_key_file"`  // pem encoded private key of the client certificate
+			MqttTlsServerName   string         `yaml:"mqtt_tls_server_name"`  // server name to verify the broker's certificate against instead of mqtt_host
+			MqttTlsMinVersion   string         `yaml:"mqtt_tls_min_version"`  // minimum tls version: 1.0, 1.1, 1.2, or 1.3
+			MqttBrokerUrl       string         `yaml:"mqtt_broker_url"`       // broker url, e.g. wss://host/mqtt, used instead of mqtt_host and mqtt_port
+			MqttProtocolVersion int            `yaml:"mqtt_protocol_version"` // 3 or 5
+			MqttSessionExpiry   int            `yaml:"mqtt_session_expiry"`   // seconds the broker keeps the session after disconnecting; mqtt v5 only
+			MqttTopicPrefix     string         `yaml:"mqtt_topic_prefix"`     // prefix for topics published and subscribed to by this app, e.g. the command topic
+			TeslamateTopic      string         `yaml:"teslamate_topic"`       // template of teslamate's car topics with {namespace}, {car_id}, and {field} placeholders
+			TeslamateNamespace  string         `yaml:"teslamate_namespace"`   // teslamate's MQTT_NAMESPACE, if set
+			TeslamateQos        int            `yaml:"teslamate_qos"`         // qos to subscribe to teslamate's topics with: 0, 1, or 2
+			OpCooldown          int            `yaml:"cooldown"`
+			ShutdownTimeout     int            `yaml:"shutdown_timeout"` // seconds to wait on shutdown for garage door actions in progress to finish
+			MyQEmail            string         `yaml:"myq_email"`
+			MyQPass             string         `yaml:"myq_pass"`
+			CacheTokenFile      string         `yaml:"cache_token_file"`
+			TokenCacheKey       string         `yaml:"token_cache_key"`     // key to encrypt the token cache with; stored in plaintext if empty
+			HttpPort            int            `yaml:"http_port"`           // port to serve http endpoints such as /metrics; disabled if 0
+			StaleCarThreshold   int            `yaml:"stale_car_threshold"` // minutes without updates before a car is reported as stale by /readyz; disabled if 0
+			ApiToken            string         `yaml:"api_token"`           // bearer token required by the control api; api disabled if empty
+			HistoryDB           string         `yaml:"history_db"`          // location of sqlite database to record event history; disabled if empty
+			HistoryRetention    int            `yaml:"history_retention"`   // days to keep event history; kept indefinitely if 0
+			StateFile           string         `yaml:"state_file"`          // location to persist car and garage door state across restarts; disabled if empty
+			StateMaxAge         int            `yaml:"state_max_age"`       // minutes after which persisted state is considered stale and discarded on startup
+			PublicUrl           string         `yaml:"public_url"`          // url this app's http endpoints are reachable at from notification services, used for actionable notifications
+			HaDiscovery         bool           `yaml:"ha_discovery"`        // announce garage doors, car zones, and a pause switch to home assistant via mqtt discovery
+			HaDiscoveryPrefix   string         `yaml:"ha_discovery_prefix"` // topic prefix home assistant watches for discovery messages
+			Notifications       []Notification `yaml:"notifications"`       // sinks to notify of garage door actions, failures, and suppressed actions
+			Calendars           []Calendar     `yaml:"calendars"`           // ical calendars whose events pause automation
 		} `yaml:"global"`
 		GarageDoors []*GarageDoor `yaml:"garage_doors"`
 		Testing     bool
@@ -107,12 +176,63 @@ type (
 	}
 )
 
-var Config ConfigStruct
+var (
+	ErrNoGarageDoors       = errors.New("no garage doors defined, please ensure proper spacing in the config file")
+	ErrNoCars              = errors.New("no cars defined, please ensure proper spacing in the config file")
+	ErrNoGeofence          = errors.New("no supported geofences defined")
+	ErrSunRequiresLocation = errors.New("sunrise and sunset times require a circular or polygon geofence")
+	ErrMissingValue        = errors.New("value is required")
+	ErrConfirmNoPublicUrl  = errors.New("requires global.public_url so notification buttons can reach the control api")
+	ErrConfirmNoSink       = errors.New("requires a notification sink that sends confirm events")
+)
+
+// returned by Load when the config file can't be read or isn't valid yaml
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("could not load config file %s: %v", e.Path, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// returned by Load when a config value is missing or invalid
+type ValidationError struct {
+	Field string // yaml path of the invalid value, e.g. garage_doors[0].confirm_default
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
 
 const (
 	PolygonGeofenceType   = "PolygonGeofence"   // custom polygon geofence defined by multiple lat/long points
 	CircularGeofenceType  = "CircularGeofence"  // circular geofence with center point and radius
 	TeslamateGeofenceType = "TeslamateGeofence" // geofence defined in teslamate
+
+	UnexpectedStateNotify = "notify"  // report that the door settled in an unexpected state
+	UnexpectedStateRetry  = "retry"   // re-issue the requested action once
+	UnexpectedStateGiveUp = "give_up" // stop and report the failure (default)
+
+	ConfirmDefaultClose  = "close"  // close the door if a close confirmation times out
+	ConfirmDefaultCancel = "cancel" // skip the close if a close confirmation times out (default)
+
+	defaultMqttTopicPrefix   = "tesla-youq"
+	defaultHaDiscoveryPrefix = "homeassistant"
+	defaultTransitionTimeout = 60 // seconds
+	defaultPollInterval      = 5  // seconds
+	defaultConfirmTimeout    = 60 // seconds
+	defaultCalendarRefresh   = 60 // minutes
+	defaultShutdownTimeout   = 30 // seconds
 )
 
 func init() {
@@ -140,9 +260,17 @@ func (f *CustomFormatter) Format(entry *logger.Entry) ([]byte, error) {
 	// Ensure the log level string is always 5 characters
 	paddedLevel := formatLevel(entry.Level)
 
-	// Combine the timestamp with the log level and the message
-	logMessage := fmt.Sprintf("%s [%s] %s\n", timestamp, paddedLevel, entry.Message)
-	return []byte(logMessage), nil
+	// Combine the timestamp with the log level and the message, followed by any fields sorted by key
+	logMessage := fmt.Sprintf("%s [%s] %s", timestamp, paddedLevel, entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		logMessage += fmt.Sprintf(" %s=%q", key, fmt.Sprint(entry.Data[key]))
+	}
+	return []byte(logMessage + "\n"), nil
 }
 
 // checks for valid geofence values for a garage door
@@ -176,31 +304,71 @@ func (t TeslamateGeofenceTrigger) IsTriggerDefined() bool {
 	return t.From != "" && t.To != ""
 }
 
-// load yaml config
-func LoadConfig(configFile string) {
-	logger.Debugf("Attempting to read config file: %v", configFile)
-	yamlFile, err := os.ReadFile(configFile)
+// reads, parses, and validates a yaml config file, expanding env vars and reading secrets from *_file keys, and loads
+// any kml files it references; returns a *ParseError if the file can't be read or parsed, or a *ValidationError if a value is missing or invalid
+func Load(path string) (*ConfigStruct, error) {
+	config := &ConfigStruct{}
+	logger.Debugf("Attempting to read config file: %v", path)
+	yamlFile, err := os.ReadFile(path)
 	if err != nil {
-		logger.Fatalf("Could not read config file: %v", err)
-	} else {
-		logger.Debug("Config file read successfully")
+		return nil, &ParseError{Path: path, Err: err}
 	}
+	logger.Debug("Config file read successfully")
 
 	logger.Debug("Unmarshaling yaml into config object")
-	err = yaml.Unmarshal(yamlFile, &Config)
-	if err != nil {
-		logger.Fatalf("Could not load yaml from config file, received error: %v", err)
-	} else {
-		logger.Debug("Config yaml unmarshalled successfully")
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, &ParseError{Path: path, Err: err}
+	}
+	if err := resolveNode(&root); err != nil {
+		return nil, &ParseError{Path: path, Err: err}
+	}
+	if err := root.Decode(config); err != nil {
+		return nil, &ParseError{Path: path, Err: err}
+	}
+	logger.Debug("Config yaml unmarshalled successfully")
+
+	if err := config.setDefaultsAndValidate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// fills in defaults and validates the config; returns a *ValidationError for the first invalid value
+func (config *ConfigStruct) setDefaultsAndValidate() error {
+	invalid := func(field string, err error) error {
+		return &ValidationError{Field: field, Err: err}
+	}
+
+	if config.Global.MqttTopicPrefix == "" {
+		config.Global.MqttTopicPrefix = defaultMqttTopicPrefix
+	}
+	if config.Global.HaDiscoveryPrefix == "" {
+		config.Global.HaDiscoveryPrefix = defaultHaDiscoveryPrefix
+	}
+	if config.Global.ShutdownTimeout < 0 {
+		return invalid("global.shutdown_timeout", fmt.Errorf("%d must not be negative", config.Global.ShutdownTimeout))
+	} else if config.Global.ShutdownTimeout == 0 {
+		config.Global.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if err := config.validateMqttBroker(); err != nil {
+		return err
+	}
+	if err := config.validateMqttTls(); err != nil {
+		return err
+	}
+	if err := config.validateTeslamateTopic(); err != nil {
+		return err
 	}
 
 	logger.Debug("Checking garage door configs")
-	if len(Config.GarageDoors) == 0 {
-		logger.Fatal("Unable to find garage doors in config! Please ensure proper spacing in the config file")
+	if len(config.GarageDoors) == 0 {
+		return invalid("garage_doors", ErrNoGarageDoors)
 	}
-	for i, g := range Config.GarageDoors {
+	for i, g := range config.GarageDoors {
+		field := fmt.Sprintf("garage_doors[%d]", i)
 		if len(g.Cars) == 0 {
-			logger.Fatalf("No cars found for garage door #%d! Please ensure proper spacing in the config file", i)
+			return invalid(field+".cars", ErrNoCars)
 		}
 		// check if kml_file was defined, and if so, load and parse kml and set polygon geofences accordingly
 		if g.PolygonGeofence != nil && g.PolygonGeofence.KMLFile != "" {
@@ -211,39 +379,183 @@ func LoadConfig(configFile string) {
 				logger.Debug("KML file loaded successfully")
 			}
 		}
+		if g.Name == "" {
+			g.Name = g.MyQSerial
+		}
+
+		// set door transition defaults where not defined
+		if g.TransitionTimeout <= 0 {
+			g.TransitionTimeout = defaultTransitionTimeout
+		}
+		if g.PollInterval <= 0 {
+			g.PollInterval = defaultPollInterval
+		}
+		switch g.UnexpectedState {
+		case "":
+			g.UnexpectedState = UnexpectedStateGiveUp
+		case UnexpectedStateNotify, UnexpectedStateRetry, UnexpectedStateGiveUp:
+		default:
+			return invalid(field+".unexpected_state_policy", fmt.Errorf("%s must be one of %s, %s, %s", g.UnexpectedState, UnexpectedStateNotify, UnexpectedStateRetry, UnexpectedStateGiveUp))
+		}
+		if g.ConfirmTimeout <= 0 {
+			g.ConfirmTimeout = defaultConfirmTimeout
+		}
+		switch g.ConfirmDefault {
+		case "":
+			g.ConfirmDefault = ConfirmDefaultCancel
+		case ConfirmDefaultClose, ConfirmDefaultCancel:
+		default:
+			return invalid(field+".confirm_default", fmt.Errorf("%s must be one of %s, %s", g.ConfirmDefault, ConfirmDefaultClose, ConfirmDefaultCancel))
+		}
+		// otherwise every close would wait for a response nobody is asked for and apply confirm_default
+		if g.ConfirmClose {
+			if config.Global.PublicUrl == "" {
+				return invalid(field+".confirm_close", ErrConfirmNoPublicUrl)
+			}
+			if !config.sendsConfirmations() {
+				return invalid(field+".confirm_close", ErrConfirmNoSink)
+			}
+		}
+		_, hasLocation := g.Location()
+		for j, q := range g.QuietHours {
+			if err := q.Validate(); err != nil {
+				return invalid(fmt.Sprintf("%s.quiet_hours[%d]", field, j), err)
+			}
+			if q.SunRelative() && !hasLocation {
+				return invalid(fmt.Sprintf("%s.quiet_hours[%d]", field, j), ErrSunRequiresLocation)
+			}
+		}
+		if g.CloseIfOpenAt != "" {
+			t, err := ParseTimeOfDay(g.CloseIfOpenAt)
+			if err != nil {
+				return invalid(field+".close_if_open_at", err)
+			}
+			if t.SunRelative() && !hasLocation {
+				return invalid(field+".close_if_open_at", ErrSunRequiresLocation)
+			}
+		}
+
 		g.GeofenceType = g.GetGeofenceType()
 		if g.GeofenceType == "" {
-			logger.Fatalf("error: no supported geofences defined for garage door %v", g)
-		} else {
-			logger.Debugf("Garage door geofence type identified: %s", g.GeofenceType)
+			return invalid(field, ErrNoGeofence)
 		}
+		logger.Debugf("Garage door geofence type identified: %s", g.GeofenceType)
 
-		// initialize location update channel
+		// initialize location update mailbox
 		for _, c := range g.Cars {
-			c.LocationUpdate = make(chan Point, 2)
+			c.LocationUpdate = NewLocationMailbox()
 		}
 	}
 
-	logger.Info("Config loaded successfully")
+	logger.Debug("Checking calendar configs")
+	for i := range config.Global.Calendars {
+		c := &config.Global.Calendars[i]
+		field := fmt.Sprintf("global.calendars[%d]", i)
+		if c.Source == "" {
+			return invalid(field+".source", ErrMissingValue)
+		}
+		if c.Refresh <= 0 {
+			c.Refresh = defaultCalendarRefresh
+		}
+		for j, r := range c.Rules {
+			if err := r.validate(config.GarageDoors); err != nil {
+				return invalid(fmt.Sprintf("%s.rules[%d]", field, j), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns true if a notification sink sends confirm events, which it does if it doesn't filter events
+func (c ConfigStruct) sendsConfirmations() bool {
+	for _, n := range c.Global.Notifications {
+		if len(n.Events) == 0 {
+			return true
+		}
+		for _, e := range n.Events {
+			if e == "confirm" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns the kml files referenced by polygon geofences
+func (c ConfigStruct) KMLFiles() []string {
+	var files []string
+	for _, g := range c.GarageDoors {
+		if g.PolygonGeofence != nil && g.PolygonGeofence.KMLFile != "" {
+			files = append(files, g.PolygonGeofence.KMLFile)
+		}
+	}
+	return files
+}
+
+// returns an error if the rule's action is invalid or its door or car don't match a configured garage door or car
+func (r CalendarRule) validate(garageDoors []*GarageDoor) error {
+	switch r.Action {
+	case "", "open", "close":
+	default:
+		return fmt.Errorf("invalid action %s, must be open or close", r.Action)
+	}
+	if r.Door != "" && r.Car != 0 {
+		return fmt.Errorf("door and car can't both be set")
+	}
+	for _, g := range garageDoors {
+		if r.Door != "" && g.Name == r.Door {
+			return nil
+		}
+		for _, c := range g.Cars {
+			if r.Car != 0 && c.ID == r.Car {
+				return nil
+			}
+		}
+	}
+	if r.Door != "" {
+		return fmt.Errorf("no garage door named %s", r.Door)
+	}
+	if r.Car != 0 {
+		return fmt.Errorf("no car with teslamate_car_id %d", r.Car)
+	}
+	return nil
 }
 
 // loads kml file and overrides polygon geofence points with parsed data
 func loadKMLFile(p *PolygonGeofence) error {
-	fileContent, err := os.ReadFile(p.KMLFile)
-	lowerKML := strings.ToLower(string(fileContent)) // convert xml to lower to make xml tag parsing case insensitive
+	placemarks, err := parseKMLFile(p.KMLFile)
 	if err != nil {
-		logger.Infof("Could not read file %s, received error: %e", p.KMLFile, err)
 		return err
 	}
+	// set either open or close polygon geo for garage door based on Placemark's Name element
+	if points, ok := placemarks["open"]; ok {
+		p.Open = points
+	}
+	if points, ok := placemarks["close"]; ok {
+		p.Close = points
+	}
+	return nil
+}
+
+// parses the open and close placemarks from a kml file, keyed by name
+func parseKMLFile(path string) (map[string][]Point, error) {
+	fileContent, err := os.ReadFile(path)
+	lowerKML := strings.ToLower(string(fileContent)) // convert xml to lower to make xml tag parsing case insensitive
+	if err != nil {
+		logger.Infof("Could not read file %s, received error: %e", path, err)
+		return nil, err
+	}
 
 	var kml KML
 	err = xml.Unmarshal([]byte(lowerKML), &kml)
 	if err != nil {
-		logger.Infof("Could not load kml from file %s, received error: %e", p.KMLFile, err)
-		return err
+		logger.Infof("Could not load kml from file %s, received error: %e", path, err)
+		return nil, err
 	}
 
 	// loop through placemarks to get name and, if relevant, parse the coordinates accordingly
+	placemarks := map[string][]Point{}
 	for _, placemark := range kml.Document.Placemarks {
 		var polygonGeoPoints []Point
 		// geofences must be named `open` or `close` or they're considered irrelevant
@@ -260,28 +572,24 @@ func loadKMLFile(p *PolygonGeofence) error {
 
 			// kml coordinate format is longitude,latitude; split comma delim and parse coords
 			coords := strings.Split(c, ",")
+			if len(coords) < 2 {
+				return nil, fmt.Errorf("could not parse lng/lat coordinates from line %s", c)
+			}
 			lat, err := strconv.ParseFloat(coords[1], 64)
 			if err != nil {
 				logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %e", c, err)
-				return err
+				return nil, err
 			}
 			lng, err := strconv.ParseFloat(coords[0], 64)
 			if err != nil {
 				logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %e", c, err)
-				return err
+				return nil, err
 			}
 
 			polygonGeoPoints = append(polygonGeoPoints, Point{Lat: lat, Lng: lng})
 		}
-
-		// set either open or close polygon geo for garage door based on Placemark's Name element
-		switch placemark.Name {
-		case "open":
-			p.Open = polygonGeoPoints
-		case "close":
-			p.Close = polygonGeoPoints
-		}
+		placemarks[placemark.Name] = polygonGeoPoints
 	}
 
-	return nil
+	return placemarks, nil
 }
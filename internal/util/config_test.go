@@ -0,0 +1,138 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Load(t *testing.T) {
+	config, err := Load(filepath.Join("..", "..", "config.example.yml"))
+	assert.NoError(t, err)
+	assert.Len(t, config.GarageDoors, 3)
+	assert.Equal(t, PolygonGeofenceType, config.GarageDoors[2].GeofenceType)
+	assert.Empty(t, config.Global.Calendars)
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  calendars:\n    - source: garage.ics\ngarage_doors:\n  - teslamate_geofence:\n      close_trigger: {from: home, to: not_home}\n    cars:\n      - teslamate_car_id: 1\n"), 0600))
+	config, err = Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 60, config.Global.Calendars[0].Refresh) // default refresh
+}
+
+func Test_Load_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	_, err := Load(path)
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr) // missing file
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  cooldown: 5\n"), 0600))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrNoGarageDoors)
+
+	assert.NoError(t, os.WriteFile(path, []byte("garage_doors:\n  - name: main\n    cars:\n      - teslamate_car_id: 1\n"), 0600))
+	_, err = Load(path)
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "garage_doors[0]", validationErr.Field)
+	assert.ErrorIs(t, err, ErrNoGeofence)
+
+	assert.NoError(t, os.WriteFile(path, []byte("garage_doors:\n  - name: main\n    confirm_default: maybe\n    cars:\n      - teslamate_car_id: 1\n"), 0600))
+	_, err = Load(path)
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "garage_doors[0].confirm_default", validationErr.Field)
+
+	// close confirmations need a way to respond
+	confirmDoor := "garage_doors:\n  - name: main\n    confirm_close: true\n    cars:\n      - teslamate_car_id: 1\n"
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  notifications:\n    - type: ntfy\n      url: https://ntfy.example.com/garage\n"+confirmDoor), 0600))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrConfirmNoPublicUrl)
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "garage_doors[0].confirm_close", validationErr.Field)
+
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  public_url: http://tesla-youq.local:8080\n  notifications:\n    - type: ntfy\n      url: https://ntfy.example.com/garage\n      events: [failure]\n"+confirmDoor), 0600))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrConfirmNoSink)
+}
+
+// configs are independent of each other, so changes to one aren't seen by another
+func Test_Load_Independent(t *testing.T) {
+	path := filepath.Join("..", "..", "config.example.yml")
+	a, err := Load(path)
+	assert.NoError(t, err)
+	b, err := Load(path)
+	assert.NoError(t, err)
+	a.GarageDoors[0].Name = "changed"
+	assert.NotEqual(t, a.GarageDoors[0].Name, b.GarageDoors[0].Name)
+}
+
+func Test_Load_EnvAndSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	secret := filepath.Join(dir, "mqtt_pass")
+	assert.NoError(t, os.WriteFile(secret, []byte("pa:ss #1\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cooldown"), []byte("10\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "chat_id"), []byte("42\n"), 0600))
+	t.Setenv("TEST_MQTT_HOST", "broker")
+	t.Setenv("TEST_MQTT_PORT", "8883")
+	t.Setenv("TEST_SECRET_DIR", dir)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`global:
+  mqtt_host: ${TEST_MQTT_HOST}.lan
+  mqtt_port: ${TEST_MQTT_PORT}
+  mqtt_user: ${TEST_MQTT_USER:-tesla}
+  mqtt_pass_file: ${TEST_SECRET_DIR}/mqtt_pass
+  myq_pass: "$${NOT_EXPANDED}"
+  cooldown_file: ${TEST_SECRET_DIR}/cooldown
+  notifications:
+    - type: telegram
+      token: bot
+      chat_id_file: ${TEST_SECRET_DIR}/chat_id
+garage_doors:
+  - name: main
+    teslamate_geofence:
+      close_trigger: {from: home, to: not_home}
+    cars:
+      - teslamate_car_id: 1
+`), 0600))
+	config, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "broker.lan", config.Global.MqttHost)
+	assert.Equal(t, 8883, config.Global.MqttPort)
+	assert.Equal(t, "tesla", config.Global.MqttUser)
+	assert.Equal(t, "pa:ss #1", config.Global.MqttPass)
+	assert.Equal(t, "${NOT_EXPANDED}", config.Global.MyQPass)
+	// any key with a single value can be read from a file, converted to the key's type
+	assert.Equal(t, 10, config.Global.OpCooldown)
+	assert.Equal(t, "42", config.Global.Notifications[0].ChatID)
+
+	// unset env vars without a default and unreadable secret files are errors with the line they're on
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  mqtt_host: ${TEST_UNSET_HOST}\n"), 0600))
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "line 2: environment variable TEST_UNSET_HOST is not set")
+
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  api_token_file: /nonexistent/token\n"), 0600))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, os.WriteFile(path, []byte("global:\n  mqtt_pass: pass\n  mqtt_pass_file: "+secret+"\n"), 0600))
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "line 3: only one of mqtt_pass and mqtt_pass_file may be defined")
+}
+
+func Test_CustomFormatter(t *testing.T) {
+	entry := logger.WithFields(logger.Fields{"topic": "teslamate/cars/1/latitude", "reason": "invalid_payload"})
+	entry.Message = "Dropped MQTT message"
+	entry.Level = logger.WarnLevel
+	entry.Time = time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	out, err := (&CustomFormatter{}).Format(entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "01/02/2024 15:04:05 [warni] Dropped MQTT message reason=\"invalid_payload\" topic=\"teslamate/cars/1/latitude\"\n", string(out))
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrCoordinateOutOfRange = errors.New("coordinate out of range")
+
+// parses a latitude or longitude from a teslamate mqtt payload, rejecting values that aren't finite numbers or are
+// outside -90 to 90 for latitudes and -180 to 180 for longitudes
+func ParseCoordinate(field string, payload string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%s %q is not a number", field, payload)
+	}
+	limit := 180.0
+	if field == "latitude" {
+		limit = 90
+	}
+	if math.Abs(value) > limit {
+		return 0, fmt.Errorf("%w: %s %v must be between %v and %v", ErrCoordinateOutOfRange, field, value, -limit, limit)
+	}
+	return value, nil
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseCoordinate(t *testing.T) {
+	value, err := ParseCoordinate("latitude", "46.19290")
+	assert.NoError(t, err)
+	assert.Equal(t, 46.1929, value)
+	value, err = ParseCoordinate("longitude", " -123.79320\n")
+	assert.NoError(t, err)
+	assert.Equal(t, -123.7932, value)
+
+	_, err = ParseCoordinate("latitude", "91")
+	assert.ErrorIs(t, err, ErrCoordinateOutOfRange)
+	_, err = ParseCoordinate("longitude", "-180.5")
+	assert.ErrorIs(t, err, ErrCoordinateOutOfRange)
+	for _, payload := range []string{"", "north", "NaN", "Inf", "1e400"} {
+		_, err = ParseCoordinate("latitude", payload)
+		assert.ErrorContains(t, err, "is not a number", payload)
+	}
+}
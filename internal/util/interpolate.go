@@ -0,0 +1,142 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// suffix of keys whose value is read from the file they reference, e.g. mqtt_pass_file for docker or kubernetes
+// secrets
+const secretFileSuffix = "_file"
+
+// matches $${...} (escaped), ${VAR}, and ${VAR:-default}
+var envReference = regexp.MustCompile(`\$\$\{[^}]*\}|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expands env var references in scalar values of a config document and replaces <key>_file keys with <key> set to the
+// contents of the file they reference; nodes keep their line numbers, and errors are prefixed with the line they
+// occur on
+func resolveNode(root *yaml.Node) error {
+	if err := interpolateNode(root); err != nil {
+		return err
+	}
+	return resolveFiles(document(root), reflect.TypeOf(ConfigStruct{}))
+}
+
+// expands env var references in every scalar value
+func interpolateNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return interpolate(node)
+	}
+	for _, n := range node.Content {
+		if err := interpolateNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads <key>_file keys of a mapping decoded into t, where <key> is a field with a single value and <key>_file isn't
+// a field itself, like kml_file, recursing into nested mappings and sequences
+func resolveFiles(node *yaml.Node, t reflect.Type) error {
+	if node == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		keys := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keys[node.Content[i].Value] = true
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if fieldType, ok := fields[key.Value]; ok {
+				if err := resolveFiles(value, fieldType); err != nil {
+					return err
+				}
+				continue
+			}
+			name := strings.TrimSuffix(key.Value, secretFileSuffix)
+			fieldType, ok := fields[name]
+			if name == key.Value || !ok || !scalarKind(fieldType) {
+				continue // left for checkUnknownKeys to report
+			}
+			if keys[name] {
+				return fmt.Errorf("line %d: only one of %s and %s may be defined", key.Line, name, key.Value)
+			}
+			data, err := os.ReadFile(value.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: unable to read %s: %w", key.Line, key.Value, err)
+			}
+			key.Value = name
+			value.SetString(strings.TrimRight(string(data), "\r\n")) // files created with echo or editors end in a newline
+			if fieldType.Kind() != reflect.String {
+				value.Tag = "" // let the contents resolve to the field's type, e.g. mqtt_port_file
+			}
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := resolveFiles(n, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := resolveFiles(node.Content[i], t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// returns true for types decoded from a single yaml scalar
+func scalarKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// expands ${VAR} and ${VAR:-default} in a scalar; $${VAR} is left as the literal ${VAR}, and references to unset env
+// vars without a default are an error
+func interpolate(node *yaml.Node) error {
+	if !strings.Contains(node.Value, "${") {
+		return nil
+	}
+	var err error
+	value := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := envReference.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && (v != "" || m[2] == "") {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("line %d: environment variable %s is not set; write $${%s} for a literal ${%s}", node.Line, m[1], m[1], m[1])
+		}
+		return ""
+	})
+	if err != nil {
+		return err
+	}
+	node.Value = value
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		node.Tag = "" // let unquoted values resolve to their type after expansion, e.g. mqtt_port: ${MQTT_PORT}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// holds the latest latitude and longitude received for a car until its geofence check picks them up; updates that
+// arrive while a check is running are coalesced into the latest value instead of queueing, and updates older than the
+// latest already received are discarded, so a slow check never processes stale or out of order fixes
+type LocationMailbox struct {
+	mutex     sync.Mutex
+	pending   Point     // latest coordinates not yet received; 0 if not updated
+	latTime   time.Time // timestamp of the latest latitude put
+	lngTime   time.Time // timestamp of the latest longitude put
+	depth     int       // updates put since the last receive, including coalesced ones
+	coalesced uint64    // total updates replaced by a newer update before being received
+	stale     uint64    // total updates discarded because a newer update was already put
+	ready     chan struct{}
+	closed    bool
+}
+
+func NewLocationMailbox() *LocationMailbox {
+	return &LocationMailbox{ready: make(chan struct{}, 1)}
+}
+
+// puts a latitude or longitude update received at timestamp, leaving the other coordinate as 0; returns false if the
+// update is older than the latest one for the same coordinate and was discarded
+func (m *LocationMailbox) Put(update Point, timestamp time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return false
+	}
+	if update.Lat != 0 {
+		if timestamp.Before(m.latTime) {
+			m.stale++
+			return false
+		}
+		if m.pending.Lat != 0 {
+			m.coalesced++
+		}
+		m.pending.Lat, m.latTime = update.Lat, timestamp
+	}
+	if update.Lng != 0 {
+		if timestamp.Before(m.lngTime) {
+			m.stale++
+			return false
+		}
+		if m.pending.Lng != 0 {
+			m.coalesced++
+		}
+		m.pending.Lng, m.lngTime = update.Lng, timestamp
+	}
+	m.depth++
+	select {
+	case m.ready <- struct{}{}:
+	default: // already signaled
+	}
+	return true
+}
+
+// blocks until an update is available and returns the latest coordinates put since the last receive; ok is false once
+// the mailbox is closed
+func (m *LocationMailbox) Receive() (update Point, ok bool) {
+	for range m.ready {
+		m.mutex.Lock()
+		update, m.pending, m.depth = m.pending, Point{}, 0
+		closed := m.closed
+		m.mutex.Unlock()
+		if closed {
+			return Point{}, false
+		}
+		if update.Lat != 0 || update.Lng != 0 {
+			return update, true
+		}
+	}
+	return Point{}, false
+}
+
+// stops accepting updates and unblocks Receive, e.g. when the car is removed by a config reload
+func (m *LocationMailbox) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.ready)
+	}
+}
+
+// returns the number of updates waiting to be received, which are coalesced into at most one location
+func (m *LocationMailbox) Depth() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.depth
+}
+
+// returns the total updates coalesced into a newer update and discarded as stale
+func (m *LocationMailbox) Dropped() (coalesced uint64, stale uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.coalesced, m.stale
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LocationMailbox(t *testing.T) {
+	m := NewLocationMailbox()
+	start := time.Now()
+
+	// updates put before a receive are coalesced into the latest location
+	assert.True(t, m.Put(Point{Lat: 46.1}, start))
+	assert.True(t, m.Put(Point{Lng: -123.7}, start.Add(time.Second)))
+	assert.True(t, m.Put(Point{Lat: 46.2}, start.Add(2*time.Second)))
+	assert.Equal(t, 3, m.Depth())
+	update, ok := m.Receive()
+	assert.True(t, ok)
+	assert.Equal(t, Point{Lat: 46.2, Lng: -123.7}, update)
+	assert.Equal(t, 0, m.Depth())
+
+	// an update older than the latest for the same coordinate is discarded
+	assert.False(t, m.Put(Point{Lat: 46.15}, start.Add(time.Second)))
+	assert.True(t, m.Put(Point{Lng: -123.8}, start.Add(3*time.Second)))
+	update, ok = m.Receive()
+	assert.True(t, ok)
+	assert.Equal(t, Point{Lng: -123.8}, update)
+	coalesced, stale := m.Dropped()
+	assert.Equal(t, uint64(1), coalesced)
+	assert.Equal(t, uint64(1), stale)
+
+	// receive blocks until an update is put, and returns once the mailbox is closed
+	received := make(chan Point)
+	go func() {
+		update, _ := m.Receive()
+		received <- update
+	}()
+	assert.True(t, m.Put(Point{Lat: 46.3}, start.Add(4*time.Second)))
+	select {
+	case update := <-received:
+		assert.Equal(t, Point{Lat: 46.3}, update)
+	case <-time.After(time.Second):
+		t.Fatal("update wasn't received")
+	}
+	m.Close()
+	_, ok = m.Receive()
+	assert.False(t, ok)
+	assert.False(t, m.Put(Point{Lat: 46.4}, start.Add(5*time.Second)))
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	MqttProtocolV3 = 3 // mqtt 3.1.1, falling back to 3.1
+	MqttProtocolV5 = 5
+)
+
+var (
+	ErrRequiresMqttV5 = errors.New("requires mqtt_protocol_version 5")
+
+	mqttSchemes       = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"} // supported by both mqtt clients
+	mqttSecureSchemes = map[string]bool{"ssl": true, "tls": true, "mqtts": true, "wss": true}
+)
+
+// returns the url of the mqtt broker, either mqtt_broker_url or built from mqtt_host, mqtt_port, and mqtt_use_tls
+func (c ConfigStruct) MqttBrokerURL() string {
+	g := c.Global
+	if g.MqttBrokerUrl != "" {
+		return g.MqttBrokerUrl
+	}
+	scheme := "tcp"
+	if g.MqttUseTls {
+		scheme = "ssl"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, g.MqttHost, g.MqttPort)
+}
+
+// returns true if the mqtt connection uses tls, either because mqtt_use_tls is true or mqtt_broker_url has a secure
+// scheme such as wss
+func (c ConfigStruct) MqttUsesTls() bool {
+	if c.Global.MqttUseTls {
+		return true
+	}
+	u, err := url.Parse(c.Global.MqttBrokerUrl)
+	return err == nil && mqttSecureSchemes[strings.ToLower(u.Scheme)]
+}
+
+// sets the default mqtt protocol version and validates the broker url and v5 options
+func (c *ConfigStruct) validateMqttBroker() error {
+	g := &c.Global
+	if g.MqttBrokerUrl != "" {
+		u, err := url.Parse(g.MqttBrokerUrl)
+		if err != nil {
+			return &ValidationError{Field: "global.mqtt_broker_url", Err: err}
+		}
+		supported := false
+		for _, scheme := range mqttSchemes {
+			supported = supported || strings.ToLower(u.Scheme) == scheme
+		}
+		if !supported {
+			return &ValidationError{Field: "global.mqtt_broker_url", Err: fmt.Errorf("scheme %q must be one of %s", u.Scheme, strings.Join(mqttSchemes, ", "))}
+		}
+		if u.Host == "" {
+			return &ValidationError{Field: "global.mqtt_broker_url", Err: fmt.Errorf("%s has no host", g.MqttBrokerUrl)}
+		}
+	}
+
+	switch g.MqttProtocolVersion {
+	case 0:
+		g.MqttProtocolVersion = MqttProtocolV3
+	case MqttProtocolV3, MqttProtocolV5:
+	default:
+		return &ValidationError{Field: "global.mqtt_protocol_version", Err: fmt.Errorf("%d must be one of %d, %d", g.MqttProtocolVersion, MqttProtocolV3, MqttProtocolV5)}
+	}
+	if g.MqttSessionExpiry < 0 {
+		return &ValidationError{Field: "global.mqtt_session_expiry", Err: fmt.Errorf("%d must not be negative", g.MqttSessionExpiry)}
+	}
+	if g.MqttSessionExpiry > 0 && g.MqttProtocolVersion != MqttProtocolV5 {
+		return &ValidationError{Field: "global.mqtt_session_expiry", Err: ErrRequiresMqttV5}
+	}
+	return nil
+}
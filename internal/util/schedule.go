@@ -0,0 +1,148 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// window during which automatic garage door actions are suppressed, e.g. no opens between 01:00 and 05:00;
+// times are in the local timezone, which can be set with the TZ env var
+type QuietHours struct {
+	Action string   `yaml:"action"` // open or close; applies to both if empty
+	Days   []string `yaml:"days"`   // days the window starts on, e.g. mon or weekdays; every day if empty
+	From   string   `yaml:"from"`   // start time as HH:MM or relative to the sun, e.g. sunset+30m; start of day if empty
+	To     string   `yaml:"to"`     // end time as HH:MM or relative to the sun, exclusive; end of day if empty; windows ending before they start span midnight
+}
+
+var (
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+	dayGroups = map[string][]time.Weekday{
+		"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		"weekends": {time.Saturday, time.Sunday},
+	}
+)
+
+// returns the first quiet hours window suppressing the action on the garage door at time t
+func (g GarageDoor) ActiveQuietHours(action string, t time.Time) (QuietHours, bool) {
+	location, _ := g.Location()
+	for _, q := range g.QuietHours {
+		if q.Matches(action, t, location) {
+			return q, true
+		}
+	}
+	return QuietHours{}, false
+}
+
+// returns an error if the quiet hours window can't be parsed
+func (q QuietHours) Validate() error {
+	switch q.Action {
+	case "", "open", "close":
+	default:
+		return fmt.Errorf("invalid action %s, must be open or close", q.Action)
+	}
+	if _, err := q.days(); err != nil {
+		return err
+	}
+	if _, err := parseBound(q.From, 0); err != nil {
+		return err
+	}
+	if _, err := parseBound(q.To, 24*time.Hour); err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns true if either bound of the window is relative to sunrise or sunset
+func (q QuietHours) SunRelative() bool {
+	from, _ := parseBound(q.From, 0)
+	to, _ := parseBound(q.To, 24*time.Hour)
+	return from.SunRelative() || to.SunRelative()
+}
+
+// returns true if the window suppresses the action at time t; sun relative bounds are calculated for location,
+// and never match on days the sun doesn't rise or set; assumes the window is valid
+func (q QuietHours) Matches(action string, t time.Time, location Point) bool {
+	if q.Action != "" && q.Action != action {
+		return false
+	}
+	days, _ := q.days()
+	fromBound, _ := parseBound(q.From, 0)
+	toBound, _ := parseBound(q.To, 24*time.Hour)
+	from, fromOk := fromBound.SinceMidnight(t, location)
+	to, toOk := toBound.SinceMidnight(t, location)
+	if !fromOk || !toOk {
+		return false
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if from <= to {
+		return days[t.Weekday()] && sinceMidnight >= from && sinceMidnight < to
+	}
+	// window spans midnight, so the early morning part belongs to the window that started the previous day
+	if sinceMidnight >= from {
+		return days[t.Weekday()]
+	}
+	return sinceMidnight < to && days[(t.Weekday()+6)%7]
+}
+
+func (q QuietHours) String() string {
+	s := "quiet hours"
+	if q.Action != "" {
+		s += " for " + q.Action
+	}
+	if len(q.Days) > 0 {
+		s += " on " + strings.Join(q.Days, ",")
+	}
+	if q.From != "" || q.To != "" {
+		from, to := q.From, q.To
+		if from == "" {
+			from = "00:00"
+		}
+		if to == "" {
+			to = "24:00"
+		}
+		s += fmt.Sprintf(" %s-%s", from, to)
+	}
+	return s
+}
+
+// returns the set of days the window starts on
+func (q QuietHours) days() (map[time.Weekday]bool, error) {
+	days := map[time.Weekday]bool{}
+	if len(q.Days) == 0 {
+		for _, d := range weekdays {
+			days[d] = true
+		}
+		return days, nil
+	}
+	for _, name := range q.Days {
+		name = strings.ToLower(name)
+		if d, ok := weekdays[name]; ok {
+			days[d] = true
+		} else if group, ok := dayGroups[name]; ok {
+			for _, d := range group {
+				days[d] = true
+			}
+		} else {
+			return nil, fmt.Errorf("invalid day %s, must be one of sun, mon, tue, wed, thu, fri, sat, weekdays, weekends", name)
+		}
+	}
+	return days, nil
+}
+
+// parses a window bound, returning the clock time def if empty
+func parseBound(s string, def time.Duration) (TimeOfDay, error) {
+	if s == "" {
+		return TimeOfDay{clock: def}, nil
+	}
+	return ParseTimeOfDay(s)
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// returns a time on the given day of the week starting sunday 2023-10-01
+func weekTime(day time.Weekday, hour int, minute int) time.Time {
+	return time.Date(2023, 10, 1+int(day), hour, minute, 0, 0, time.Local)
+}
+
+func Test_QuietHours_Matches(t *testing.T) {
+	night := QuietHours{Action: "open", From: "01:00", To: "05:00"}
+	assert.True(t, night.Matches("open", weekTime(time.Monday, 1, 0), Point{}))
+	assert.True(t, night.Matches("open", weekTime(time.Monday, 4, 59), Point{}))
+	assert.False(t, night.Matches("open", weekTime(time.Monday, 5, 0), Point{}))
+	assert.False(t, night.Matches("close", weekTime(time.Monday, 2, 0), Point{}))
+
+	weekdays := QuietHours{Action: "close", Days: []string{"weekdays"}}
+	assert.True(t, weekdays.Matches("close", weekTime(time.Friday, 23, 59), Point{}))
+	assert.False(t, weekdays.Matches("close", weekTime(time.Saturday, 12, 0), Point{}))
+
+	// spans midnight, starting friday night
+	overnight := QuietHours{Days: []string{"fri"}, From: "22:00", To: "06:00"}
+	assert.True(t, overnight.Matches("open", weekTime(time.Friday, 22, 0), Point{}))
+	assert.True(t, overnight.Matches("close", weekTime(time.Saturday, 5, 59), Point{}))
+	assert.False(t, overnight.Matches("open", weekTime(time.Friday, 5, 0), Point{}))
+	assert.False(t, overnight.Matches("open", weekTime(time.Saturday, 22, 0), Point{}))
+}
+
+func Test_QuietHours_Validate(t *testing.T) {
+	assert.NoError(t, QuietHours{Action: "open", Days: []string{"Mon", "weekends"}, From: "01:00", To: "24:00"}.Validate())
+	assert.Error(t, QuietHours{Action: "toggle"}.Validate())
+	assert.Error(t, QuietHours{Days: []string{"someday"}}.Validate())
+	assert.Error(t, QuietHours{From: "1am"}.Validate())
+	assert.Error(t, QuietHours{To: "25:00"}.Validate())
+}
+
+func Test_ActiveQuietHours(t *testing.T) {
+	g := GarageDoor{QuietHours: []QuietHours{{Action: "open", From: "01:00", To: "05:00"}, {Action: "close", Days: []string{"sun"}}}}
+
+	q, ok := g.ActiveQuietHours("close", weekTime(time.Sunday, 3, 0))
+	assert.True(t, ok)
+	assert.Equal(t, "quiet hours for close on sun", q.String())
+
+	_, ok = g.ActiveQuietHours("open", weekTime(time.Monday, 12, 0))
+	assert.False(t, ok)
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// matches teslamate's own topics: teslamate/cars/<id>/<field>, or teslamate/<namespace>/cars/<id>/<field> if
+	// teslamate's MQTT_NAMESPACE is set
+	DefaultTeslamateTopic = "teslamate/{namespace}/cars/{car_id}/{field}"
+
+	namespacePlaceholder = "{namespace}"
+	carIDPlaceholder     = "{car_id}"
+	fieldPlaceholder     = "{field}"
+)
+
+// teslamate topic template with the namespace filled in, split into topic levels; {car_id} and {field} must each be
+// a whole level so they can be parsed back out of received topics
+type TopicTemplate struct {
+	levels []string
+}
+
+// creates a topic template from a template with {namespace}, {car_id}, and {field} placeholders; levels left empty by
+// an empty namespace are dropped, so the default template works with and without a teslamate namespace
+func NewTopicTemplate(template string, namespace string) (TopicTemplate, error) {
+	var levels []string
+	carIDs, fields := 0, 0
+	for _, level := range strings.Split(strings.ReplaceAll(template, namespacePlaceholder, namespace), "/") {
+		switch {
+		case level == "":
+			continue
+		case level == carIDPlaceholder:
+			carIDs++
+		case level == fieldPlaceholder:
+			fields++
+		case strings.ContainsAny(level, "+#"):
+			return TopicTemplate{}, fmt.Errorf("template %q must not contain the wildcards + or #", template)
+		case strings.Contains(level, "{"):
+			return TopicTemplate{}, fmt.Errorf("unknown placeholder in %q, supported placeholders are %s, %s, and %s as whole topic levels", level, namespacePlaceholder, carIDPlaceholder, fieldPlaceholder)
+		}
+		levels = append(levels, level)
+	}
+	if carIDs != 1 || fields != 1 {
+		return TopicTemplate{}, fmt.Errorf("template %q must contain %s and %s exactly once", template, carIDPlaceholder, fieldPlaceholder)
+	}
+	return TopicTemplate{levels: levels}, nil
+}
+
+// returns the topic of a field for a car, e.g. teslamate/cars/1/latitude
+func (t TopicTemplate) Topic(carID int, field string) string {
+	levels := make([]string, len(t.levels))
+	for i, level := range t.levels {
+		switch level {
+		case carIDPlaceholder:
+			levels[i] = strconv.Itoa(carID)
+		case fieldPlaceholder:
+			levels[i] = field
+		default:
+			levels[i] = level
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// extracts the car id and field from a topic matching the template; ok is false if the topic doesn't match
+func (t TopicTemplate) Parse(topic string) (carID int, field string, ok bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(t.levels) {
+		return 0, "", false
+	}
+	for i, level := range t.levels {
+		switch level {
+		case carIDPlaceholder:
+			id, err := strconv.Atoi(levels[i])
+			if err != nil {
+				return 0, "", false
+			}
+			carID = id
+		case fieldPlaceholder:
+			field = levels[i]
+		default:
+			if levels[i] != level {
+				return 0, "", false
+			}
+		}
+	}
+	return carID, field, true
+}
+
+// returns the teslamate topic template of the config, which is validated by Load
+func (c ConfigStruct) TeslamateTopics() TopicTemplate {
+	t, _ := NewTopicTemplate(c.Global.TeslamateTopic, c.Global.TeslamateNamespace)
+	return t
+}
+
+// sets the default teslamate topic template and validates it and the qos
+func (c *ConfigStruct) validateTeslamateTopic() error {
+	g := &c.Global
+	if g.TeslamateTopic == "" {
+		g.TeslamateTopic = DefaultTeslamateTopic
+	}
+	if strings.ContainsAny(g.TeslamateNamespace, "+#{}") {
+		return &ValidationError{Field: "global.teslamate_namespace", Err: fmt.Errorf("%q must not contain the wildcards + or # or placeholders", g.TeslamateNamespace)}
+	}
+	if _, err := NewTopicTemplate(g.TeslamateTopic, g.TeslamateNamespace); err != nil {
+		return &ValidationError{Field: "global.teslamate_topic", Err: err}
+	}
+	if g.TeslamateQos < 0 || g.TeslamateQos > 2 {
+		return &ValidationError{Field: "global.teslamate_qos", Err: fmt.Errorf("%d must be 0, 1, or 2", g.TeslamateQos)}
+	}
+	return nil
+}
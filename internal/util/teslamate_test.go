@@ -0,0 +1,65 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TopicTemplate(t *testing.T) {
+	template, err := NewTopicTemplate(DefaultTeslamateTopic, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "teslamate/cars/1/latitude", template.Topic(1, "latitude"))
+	carID, field, ok := template.Parse("teslamate/cars/12/geofence")
+	assert.True(t, ok)
+	assert.Equal(t, 12, carID)
+	assert.Equal(t, "geofence", field)
+
+	template, err = NewTopicTemplate(DefaultTeslamateTopic, "home")
+	assert.NoError(t, err)
+	assert.Equal(t, "teslamate/home/cars/1/longitude", template.Topic(1, "longitude"))
+	carID, field, ok = template.Parse("teslamate/home/cars/2/longitude")
+	assert.True(t, ok)
+	assert.Equal(t, 2, carID)
+	assert.Equal(t, "longitude", field)
+
+	// topics that don't match the template
+	for _, topic := range []string{"teslamate/cars/2/longitude", "teslamate/away/cars/2/longitude", "teslamate/home/cars/two/longitude", "teslamate/home/cars/2"} {
+		_, _, ok = template.Parse(topic)
+		assert.False(t, ok, topic)
+	}
+
+	// custom template with the field before the car id
+	template, err = NewTopicTemplate("bridge/{field}/car-{car_id}", "")
+	assert.Error(t, err) // placeholders must be whole levels
+	template, err = NewTopicTemplate("bridge/{field}/{car_id}", "")
+	assert.NoError(t, err)
+	carID, field, ok = template.Parse("bridge/latitude/3")
+	assert.True(t, ok)
+	assert.Equal(t, 3, carID)
+	assert.Equal(t, "latitude", field)
+
+	_, err = NewTopicTemplate("teslamate/cars/{car_id}", "")
+	assert.ErrorContains(t, err, "exactly once")
+	_, err = NewTopicTemplate("teslamate/+/cars/{car_id}/{field}", "")
+	assert.ErrorContains(t, err, "wildcards")
+	_, err = NewTopicTemplate("teslamate/{ns}/cars/{car_id}/{field}", "")
+	assert.ErrorContains(t, err, "unknown placeholder")
+}
+
+func Test_validateTeslamateTopic(t *testing.T) {
+	config := ConfigStruct{}
+	assert.NoError(t, config.validateTeslamateTopic())
+	assert.Equal(t, DefaultTeslamateTopic, config.Global.TeslamateTopic)
+	assert.Equal(t, "teslamate/cars/1/geofence", config.TeslamateTopics().Topic(1, "geofence"))
+
+	var validationErr *ValidationError
+	config.Global.TeslamateNamespace = "home/#"
+	assert.ErrorAs(t, config.validateTeslamateTopic(), &validationErr)
+	assert.Equal(t, "global.teslamate_namespace", validationErr.Field)
+
+	config.Global.TeslamateNamespace = "home"
+	config.Global.TeslamateQos = 3
+	assert.ErrorAs(t, config.validateTeslamateTopic(), &validationErr)
+	assert.Equal(t, "global.teslamate_qos", validationErr.Field)
+}
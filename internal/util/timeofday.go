@@ -0,0 +1,112 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sun "github.com/brchri/tesla-youq/internal/sun"
+)
+
+const (
+	SunriseEvent = "sunrise"
+	SunsetEvent  = "sunset"
+)
+
+// time of day as a clock time (HH:MM) or relative to sunrise or sunset with an optional offset, e.g. sunset+30m
+type TimeOfDay struct {
+	clock  time.Duration // since midnight, for clock times
+	event  string        // sunrise or sunset, for sun relative times
+	offset time.Duration // added to the sun event
+}
+
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, event := range []string{SunriseEvent, SunsetEvent} {
+		if !strings.HasPrefix(s, event) {
+			continue
+		}
+		d := TimeOfDay{event: event}
+		if rest := strings.TrimPrefix(s, event); rest != "" {
+			offset, err := time.ParseDuration(strings.TrimPrefix(rest, "+"))
+			if err != nil || (rest[0] != '+' && rest[0] != '-') {
+				return d, fmt.Errorf("invalid offset in %s, must be formatted like %s+30m or %s-1h", s, event, event)
+			}
+			d.offset = offset
+		}
+		return d, nil
+	}
+
+	if s == "24:00" {
+		return TimeOfDay{clock: 24 * time.Hour}, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return TimeOfDay{}, fmt.Errorf("invalid time %s, must be formatted as HH:MM or relative to sunrise or sunset, e.g. sunset+30m", s)
+	}
+	return TimeOfDay{clock: time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute}, nil
+}
+
+// returns true if the time is relative to sunrise or sunset
+func (d TimeOfDay) SunRelative() bool {
+	return d.event != ""
+}
+
+// returns the time on t's calendar day as a duration since midnight; sun relative times are calculated for location,
+// and ok is false if the sun doesn't rise or set that day
+func (d TimeOfDay) SinceMidnight(t time.Time, location Point) (since time.Duration, ok bool) {
+	if !d.SunRelative() {
+		return d.clock, true
+	}
+	sunrise, sunset, ok := sun.Times(t, location.Lat, location.Lng)
+	if !ok {
+		return 0, false
+	}
+	event := sunrise
+	if d.event == SunsetEvent {
+		event = sunset
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return event.Add(d.offset).Sub(midnight), true
+}
+
+// returns the first occurrence of the time of day after t
+func (d TimeOfDay) Next(t time.Time, location Point) (time.Time, bool) {
+	for i := 0; i <= 2; i++ {
+		day := t.AddDate(0, 0, i)
+		since, ok := d.SinceMidnight(day, location)
+		if !ok {
+			continue
+		}
+		at := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()).Add(since)
+		if at.After(t) {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// returns the point used to calculate sunrise and sunset for a garage door: the center of a circular geofence, or
+// the centroid of the close (or open) polygon; ok is false for teslamate geofences, which don't define coordinates
+func (g GarageDoor) Location() (Point, bool) {
+	if g.CircularGeofence != nil && g.CircularGeofence.Center.IsPointDefined() {
+		return g.CircularGeofence.Center, true
+	}
+	if g.PolygonGeofence != nil {
+		points := g.PolygonGeofence.Close
+		if len(points) == 0 {
+			points = g.PolygonGeofence.Open
+		}
+		if len(points) > 0 {
+			var centroid Point
+			for _, p := range points {
+				centroid.Lat += p.Lat
+				centroid.Lng += p.Lng
+			}
+			centroid.Lat /= float64(len(points))
+			centroid.Lng /= float64(len(points))
+			return centroid, true
+		}
+	}
+	return Point{}, false
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var london = Point{Lat: 51.5074, Lng: -0.1278}
+
+func Test_ParseTimeOfDay(t *testing.T) {
+	for _, s := range []string{"00:00", "23:59", "24:00", "sunrise", "SUNSET", "sunset+30m", "sunrise-1h30m"} {
+		_, err := ParseTimeOfDay(s)
+		assert.NoError(t, err, s)
+	}
+	for _, s := range []string{"", "7pm", "25:00", "sunset30m", "sunset+soon", "moonrise"} {
+		_, err := ParseTimeOfDay(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_TimeOfDay_Next(t *testing.T) {
+	bst := time.FixedZone("BST", 60*60)
+	now := time.Date(2023, 6, 21, 12, 0, 0, 0, bst)
+
+	clock, _ := ParseTimeOfDay("06:00")
+	next, ok := clock.Next(now, london)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2023, 6, 22, 6, 0, 0, 0, bst), next)
+
+	afterSunset, _ := ParseTimeOfDay("sunset+30m")
+	next, ok = afterSunset.Next(now, london)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Date(2023, 6, 21, 21, 51, 0, 0, bst), next, 2*time.Minute)
+}
+
+func Test_QuietHours_Matches_SunRelative(t *testing.T) {
+	bst := time.FixedZone("BST", 60*60)
+	daytime := QuietHours{Action: "open", From: "sunrise", To: "sunset"} // only auto-open after dark
+	assert.True(t, daytime.SunRelative())
+	assert.True(t, daytime.Matches("open", time.Date(2023, 6, 21, 12, 0, 0, 0, bst), london))
+	assert.False(t, daytime.Matches("open", time.Date(2023, 6, 21, 22, 0, 0, 0, bst), london))
+
+	night := QuietHours{From: "sunset", To: "sunrise"}
+	assert.True(t, night.Matches("close", time.Date(2023, 6, 21, 23, 0, 0, 0, bst), london))
+	assert.True(t, night.Matches("close", time.Date(2023, 6, 22, 4, 0, 0, 0, bst), london))
+	assert.False(t, night.Matches("close", time.Date(2023, 6, 22, 5, 0, 0, 0, bst), london))
+}
+
+func Test_GarageDoor_Location(t *testing.T) {
+	circular := GarageDoor{CircularGeofence: &CircularGeofence{Center: london, OpenDistance: 1}}
+	location, ok := circular.Location()
+	assert.True(t, ok)
+	assert.Equal(t, london, location)
+
+	polygon := GarageDoor{PolygonGeofence: &PolygonGeofence{Open: []Point{{Lat: 1, Lng: 1}, {Lat: 3, Lng: 1}, {Lat: 3, Lng: 3}, {Lat: 1, Lng: 3}}}}
+	location, ok = polygon.Location()
+	assert.True(t, ok)
+	assert.Equal(t, Point{Lat: 2, Lng: 2}, location)
+
+	_, ok = GarageDoor{TeslamateGeofence: &TeslamateGeofence{}}.Location()
+	assert.False(t, ok)
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrRequiresTls       = errors.New("requires mqtt_use_tls to be true or a secure mqtt_broker_url scheme")
+	ErrIncompleteCertKey = errors.New("mqtt_client_cert_file and mqtt_client_key_file must be defined together")
+
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// returns the tls config for connecting to the mqtt broker, loading the ca bundle and client certificate if defined,
+// or nil if the connection doesn't use tls
+func (c ConfigStruct) MqttTLSConfig() (*tls.Config, error) {
+	g := c.Global
+	if !c.MqttUsesTls() {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: g.MqttSkipTlsVerify,
+		ServerName:         g.MqttTlsServerName,
+	}
+	if g.MqttTlsMinVersion != "" {
+		tlsConfig.MinVersion = tlsVersions[g.MqttTlsMinVersion]
+	}
+	if g.MqttCaFile != "" {
+		pool, err := loadCertPool(g.MqttCaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if g.MqttClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(g.MqttClientCertFile, g.MqttClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load mqtt client certificate %s with key %s: %w", g.MqttClientCertFile, g.MqttClientKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// loads the pem encoded certificates in a ca bundle; errors identify the file and the certificate that's invalid,
+// since x509.CertPool.AppendCertsFromPEM silently skips invalid certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mqtt_ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("mqtt_ca_file %s contains a %s block, only CERTIFICATE blocks are supported", path, block.Type)
+		}
+		n++
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d in mqtt_ca_file %s is invalid: %w", n, path, err)
+		}
+		pool.AddCert(cert)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("mqtt_ca_file %s contains no PEM encoded certificates; DER encoded certificates must be converted to PEM", path)
+	}
+	return pool, nil
+}
+
+// validates the mqtt tls options without loading any files
+func (c ConfigStruct) validateMqttTls() error {
+	g := c.Global
+	if _, ok := tlsVersions[g.MqttTlsMinVersion]; g.MqttTlsMinVersion != "" && !ok {
+		return &ValidationError{Field: "global.mqtt_tls_min_version", Err: fmt.Errorf("%s must be one of 1.0, 1.1, 1.2, 1.3", g.MqttTlsMinVersion)}
+	}
+	if (g.MqttClientCertFile == "") != (g.MqttClientKeyFile == "") {
+		return &ValidationError{Field: "global.mqtt_client_cert_file", Err: ErrIncompleteCertKey}
+	}
+	if c.MqttUsesTls() {
+		return nil
+	}
+	options := []struct{ field, value string }{
+		{"mqtt_ca_file", g.MqttCaFile},
+		{"mqtt_client_cert_file", g.MqttClientCertFile},
+		{"mqtt_tls_server_name", g.MqttTlsServerName},
+		{"mqtt_tls_min_version", g.MqttTlsMinVersion},
+	}
+	for _, o := range options {
+		if o.value != "" {
+			return &ValidationError{Field: "global." + o.field, Err: ErrRequiresTls}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writes a self signed certificate and its key as pem files and returns their paths
+func writeCertAndKey(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func Test_MqttTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile, caKeyFile := writeCertAndKey(t, dir, "ca")
+	certFile, keyFile := writeCertAndKey(t, dir, "client")
+
+	config := ConfigStruct{}
+	tlsConfig, err := config.MqttTLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig) // tls disabled
+
+	config.Global.MqttUseTls = true
+	config.Global.MqttCaFile = caFile
+	config.Global.MqttClientCertFile = certFile
+	config.Global.MqttClientKeyFile = keyFile
+	config.Global.MqttTlsServerName = "broker.internal"
+	config.Global.MqttTlsMinVersion = "1.3"
+	assert.NoError(t, config.validateMqttTls())
+	tlsConfig, err = config.MqttTLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "broker.internal", tlsConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.NotNil(t, tlsConfig.RootCAs)
+
+	// a key file in place of the ca bundle
+	config.Global.MqttCaFile = caKeyFile
+	_, err = config.MqttTLSConfig()
+	assert.ErrorContains(t, err, "contains a EC PRIVATE KEY block")
+
+	// a file that isn't pem encoded
+	garbage := filepath.Join(dir, "garbage.crt")
+	assert.NoError(t, os.WriteFile(garbage, []byte("not a certificate"), 0600))
+	config.Global.MqttCaFile = garbage
+	_, err = config.MqttTLSConfig()
+	assert.ErrorContains(t, err, "contains no PEM encoded certificates")
+
+	// a key that doesn't match the client certificate
+	config.Global.MqttCaFile = caFile
+	config.Global.MqttClientKeyFile = caKeyFile
+	_, err = config.MqttTLSConfig()
+	assert.ErrorContains(t, err, "unable to load mqtt client certificate")
+}
+
+func Test_validateMqttTls(t *testing.T) {
+	var validationErr *ValidationError
+	config := ConfigStruct{}
+	config.Global.MqttCaFile = "ca.crt"
+	err := config.validateMqttTls()
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "global.mqtt_ca_file", validationErr.Field)
+	assert.ErrorIs(t, err, ErrRequiresTls)
+
+	config.Global.MqttUseTls = true
+	config.Global.MqttClientCertFile = "client.crt"
+	assert.ErrorIs(t, config.validateMqttTls(), ErrIncompleteCertKey)
+
+	config.Global.MqttClientCertFile = ""
+	config.Global.MqttTlsMinVersion = "1.4"
+	assert.ErrorAs(t, config.validateMqttTls(), &validationErr)
+	assert.Equal(t, "global.mqtt_tls_min_version", validationErr.Field)
+}
+
+func Test_MqttBrokerURL(t *testing.T) {
+	config := ConfigStruct{}
+	config.Global.MqttHost = "localhost"
+	config.Global.MqttPort = 1883
+	assert.Equal(t, "tcp://localhost:1883", config.MqttBrokerURL())
+	assert.False(t, config.MqttUsesTls())
+	assert.NoError(t, config.validateMqttBroker())
+	assert.Equal(t, MqttProtocolV3, config.Global.MqttProtocolVersion)
+
+	config.Global.MqttBrokerUrl = "wss://proxy.example.com/mqtt"
+	assert.Equal(t, "wss://proxy.example.com/mqtt", config.MqttBrokerURL())
+	assert.True(t, config.MqttUsesTls())
+	config.Global.MqttCaFile = "ca.crt"
+	assert.NoError(t, config.validateMqttTls()) // a secure scheme doesn't require mqtt_use_tls
+
+	var validationErr *ValidationError
+	config.Global.MqttBrokerUrl = "http://proxy.example.com/mqtt"
+	assert.ErrorAs(t, config.validateMqttBroker(), &validationErr)
+	assert.Equal(t, "global.mqtt_broker_url", validationErr.Field)
+
+	config.Global.MqttBrokerUrl = "ws://proxy.example.com/mqtt"
+	config.Global.MqttSessionExpiry = 3600
+	assert.ErrorIs(t, config.validateMqttBroker(), ErrRequiresMqttV5)
+	config.Global.MqttProtocolVersion = MqttProtocolV5
+	assert.NoError(t, config.validateMqttBroker())
+	config.Global.MqttProtocolVersion = 4
+	assert.ErrorAs(t, config.validateMqttBroker(), &validationErr)
+	assert.Equal(t, "global.mqtt_protocol_version", validationErr.Field)
+}
@@ -0,0 +1,409 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SeverityError   = "error"   // the config won't load or won't behave as written
+	SeverityWarning = "warning" // the config loads but is likely a mistake
+)
+
+// problem found in a config file
+type Diagnostic struct {
+	Line     int // line in the config file, or 0 if the problem isn't tied to a line
+	Severity string
+	Message  string
+}
+
+var (
+	yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+)
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+// checks a config file for anything Load would reject, plus unknown keys, unreadable mqtt tls files, conflicting geofence types, invalid
+// or overlapping polygons, duplicate cars, and missing kml placemarks, which Load accepts silently;
+// diagnostics are sorted by line
+func ValidateConfig(configFile string) []Diagnostic {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return []Diagnostic{{Severity: SeverityError, Message: fmt.Sprintf("could not read config file: %v", err)}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []Diagnostic{yamlDiagnostic(err.Error())}
+	}
+	if err := resolveNode(&root); err != nil {
+		return []Diagnostic{yamlDiagnostic(err.Error())}
+	}
+
+	var diagnostics []Diagnostic
+	add := func(line int, severity string, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// decoding the resolved nodes reports type errors with line numbers, but unlike a strict decoder doesn't report
+	// unknown keys, e.g. from bad indentation, so those are checked separately
+	var config ConfigStruct
+	if err := root.Decode(&config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return []Diagnostic{yamlDiagnostic(err.Error())}
+		}
+		for _, e := range typeErr.Errors {
+			diagnostics = append(diagnostics, yamlDiagnostic(e))
+		}
+	}
+	checkUnknownKeys(document(&root), reflect.TypeOf(config), add)
+
+	// type errors were already reported with line numbers above
+	if _, err := Load(configFile); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			add(0, SeverityError, "%v", err)
+		}
+	}
+
+	// tls files are only loaded when connecting to the broker, so check them here to catch bad pem files early
+	if _, err := config.MqttTLSConfig(); err != nil {
+		add(keyLine(mappingValue(document(&root), "global"), "mqtt_use_tls"), SeverityError, "%v", err)
+	}
+
+	doorNodes := sequence(mappingValue(document(&root), "garage_doors"))
+	for i, g := range config.GarageDoors {
+		if g == nil || i >= len(doorNodes) {
+			continue
+		}
+		node := doorNodes[i]
+		name := g.Name
+		if name == "" {
+			name = g.MyQSerial
+		}
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+
+		checkGeofenceTypes(g, name, node, add)
+		if g.CircularGeofence != nil {
+			c := g.CircularGeofence
+			if c.OpenDistance > 0 && c.CloseDistance > c.OpenDistance {
+				add(keyLine(node, "circular_geofence"), SeverityWarning, "close_distance %v is larger than open_distance %v for garage door %s, so the open geofence doesn't contain the close geofence", c.CloseDistance, c.OpenDistance, name)
+			}
+		}
+		if g.PolygonGeofence != nil {
+			checkPolygonGeofence(g.PolygonGeofence, name, mappingValue(node, "polygon_geofence"), keyLine(node, "polygon_geofence"), add)
+		}
+
+		// a car id can be on several garage doors, which each receive its updates, but only once on each
+		carNodes := sequence(mappingValue(node, "cars"))
+		cars := map[int]int{} // line each car id was first defined on for this garage door
+		for j, c := range g.Cars {
+			if c == nil || j >= len(carNodes) {
+				continue
+			}
+			line := keyLine(carNodes[j], "teslamate_car_id")
+			if first, ok := cars[c.ID]; ok {
+				add(line, SeverityError, "duplicate teslamate_car_id %d on garage door %s, already defined on line %d", c.ID, name, first)
+				continue
+			}
+			cars[c.ID] = line
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+	return diagnostics
+}
+
+// returns true if any diagnostic is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// reports garage doors that define more than one geofence type, since only the highest priority one is used
+func checkGeofenceTypes(g *GarageDoor, name string, node *yaml.Node, add func(int, string, string, ...interface{})) {
+	var defined []string
+	if g.PolygonGeofence != nil {
+		defined = append(defined, "polygon_geofence")
+	}
+	if g.CircularGeofence != nil {
+		defined = append(defined, "circular_geofence")
+	}
+	if g.TeslamateGeofence != nil {
+		defined = append(defined, "teslamate_geofence")
+	}
+	if len(defined) > 1 {
+		add(node.Line, SeverityError, "garage door %s defines %s; only one geofence type may be defined, and %s would be used", name, strings.Join(defined, " and "), defined[0])
+	}
+}
+
+// reports invalid open and close polygons, polygons where the open geofence doesn't contain the close geofence, and
+// kml files missing open or close placemarks
+func checkPolygonGeofence(p *PolygonGeofence, name string, node *yaml.Node, line int, add func(int, string, string, ...interface{})) {
+	polygons := map[string][]Point{"open": p.Open, "close": p.Close}
+	lines := map[string]int{"open": keyLine(node, "open"), "close": keyLine(node, "close")}
+
+	if p.KMLFile != "" {
+		kmlLine := keyLine(node, "kml_file")
+		placemarks, err := parseKMLFile(p.KMLFile)
+		if err != nil {
+			if len(p.Open) == 0 && len(p.Close) == 0 {
+				add(kmlLine, SeverityError, "unable to load kml file %s for garage door %s: %v", p.KMLFile, name, err)
+				return
+			}
+			add(kmlLine, SeverityWarning, "unable to load kml file %s for garage door %s, the open and close points in the config will be used: %v", p.KMLFile, name, err)
+		} else {
+			_, hasOpen := placemarks["open"]
+			_, hasClose := placemarks["close"]
+			if !hasOpen && !hasClose {
+				severity := SeverityError
+				if len(p.Open) > 0 || len(p.Close) > 0 {
+					severity = SeverityWarning // the open and close points in the config are used instead
+				}
+				add(kmlLine, severity, "kml file %s for garage door %s has no placemarks named open or close", p.KMLFile, name)
+			}
+			for _, geofence := range []string{"open", "close"} {
+				points, ok := placemarks[geofence]
+				if !ok {
+					if hasOpen || hasClose {
+						add(kmlLine, SeverityWarning, "kml file %s for garage door %s has no placemark named %s", p.KMLFile, name, geofence)
+					}
+					continue
+				}
+				polygons[geofence] = points
+				lines[geofence] = kmlLine
+			}
+		}
+	}
+
+	valid := map[string]bool{}
+	for _, geofence := range []string{"open", "close"} {
+		points := polygons[geofence]
+		if len(points) == 0 {
+			continue
+		}
+		l := lines[geofence]
+		if l == 0 {
+			l = line
+		}
+		valid[geofence] = checkRing(points, fmt.Sprintf("%s polygon for garage door %s", geofence, name), l, add)
+	}
+
+	if valid["open"] && valid["close"] {
+		for i, pt := range ringPoints(polygons["close"]) {
+			if !onVertex(pt, polygons["open"]) && !insidePolygon(pt, polygons["open"]) {
+				add(lines["close"], SeverityWarning, "point %d of the close polygon for garage door %s is outside the open polygon, so the open geofence doesn't contain the close geofence", i+1, name)
+				break
+			}
+		}
+	}
+}
+
+// reports rings with too few points, that aren't closed, or that cross themselves; returns false if the ring can't
+// be used as a geofence
+func checkRing(points []Point, label string, line int, add func(int, string, string, ...interface{})) bool {
+	ring := ringPoints(points)
+	if len(ring) < 3 {
+		add(line, SeverityError, "%s has %d distinct points, at least 3 are required", label, len(ring))
+		return false
+	}
+	if points[0] != points[len(points)-1] {
+		add(line, SeverityWarning, "%s isn't closed; its last point will be joined to its first", label)
+	}
+	n := len(ring)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			// adjacent edges share a point, so they always touch
+			if j == i+1 || (i == 0 && j == n-1) {
+				continue
+			}
+			if segmentsIntersect(ring[i], ring[(i+1)%n], ring[j], ring[(j+1)%n]) {
+				add(line, SeverityError, "%s crosses itself between points %d-%d and %d-%d", label, i+1, (i+1)%n+1, j+1, (j+1)%n+1)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// returns the points of a polygon without consecutive duplicates or the closing point
+func ringPoints(points []Point) []Point {
+	var ring []Point
+	for _, p := range points {
+		if len(ring) > 0 && ring[len(ring)-1] == p {
+			continue
+		}
+		ring = append(ring, p)
+	}
+	if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	return ring
+}
+
+// returns true if segments a1-a2 and b1-b2 intersect or touch
+func segmentsIntersect(a1, a2, b1, b2 Point) bool {
+	d1 := orientation(b1, b2, a1)
+	d2 := orientation(b1, b2, a2)
+	d3 := orientation(a1, a2, b1)
+	d4 := orientation(a1, a2, b2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(b1, b2, a1)) || (d2 == 0 && onSegment(b1, b2, a2)) ||
+		(d3 == 0 && onSegment(a1, a2, b1)) || (d4 == 0 && onSegment(a1, a2, b2))
+}
+
+// cross product of p-a and b-a; positive if p is left of a->b, negative if right, zero if collinear
+func orientation(a, b, p Point) float64 {
+	return (b.Lng-a.Lng)*(p.Lat-a.Lat) - (b.Lat-a.Lat)*(p.Lng-a.Lng)
+}
+
+// returns true if p, which is collinear with a and b, lies between them
+func onSegment(a, b, p Point) bool {
+	return p.Lat >= min(a.Lat, b.Lat) && p.Lat <= max(a.Lat, b.Lat) && p.Lng >= min(a.Lng, b.Lng) && p.Lng <= max(a.Lng, b.Lng)
+}
+
+// returns true if p is a vertex of the polygon
+func onVertex(p Point, polygon []Point) bool {
+	for _, v := range polygon {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ray casting point in polygon test, matching the geofence check used at runtime
+func insidePolygon(p Point, polygon []Point) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := 0; i < len(polygon); i++ {
+		if (polygon[i].Lat > p.Lat) != (polygon[j].Lat > p.Lat) &&
+			p.Lng < (polygon[j].Lng-polygon[i].Lng)*(p.Lat-polygon[i].Lat)/(polygon[j].Lat-polygon[i].Lat)+polygon[i].Lng {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// converts a yaml error message, which may start with its line number, to a diagnostic
+func yamlDiagnostic(message string) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Message: message}
+	if m := yamlLine.FindStringSubmatch(message); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+		d.Message = strings.TrimPrefix(message, m[0])
+	}
+	return d
+}
+
+// reports keys in a mapping node that don't match a yaml field of the type it's decoded into, recursing into nested
+// mappings and sequences
+func checkUnknownKeys(node *yaml.Node, t reflect.Type, add func(int, string, string, ...interface{})) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				add(key.Line, SeverityError, "unknown key %s, check its spelling and indentation", key.Value)
+				continue
+			}
+			checkUnknownKeys(node.Content[i+1], fieldType, add)
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for _, n := range node.Content {
+			checkUnknownKeys(n, t.Elem(), add)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			checkUnknownKeys(node.Content[i], t.Elem(), add)
+		}
+	}
+}
+
+// returns the types of a struct's fields by yaml key; fields without a yaml tag, such as runtime state like OpLock,
+// aren't meant to be configured and are left out
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name != "" && name != "-" && f.IsExported() {
+			fields[name] = f.Type
+		}
+	}
+	return fields
+}
+
+// returns the top level node of a yaml document
+func document(root *yaml.Node) *yaml.Node {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		return root.Content[0]
+	}
+	return root
+}
+
+// returns the value node for a key in a mapping node, or nil if it isn't found
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// returns the line of a key in a mapping node, or the mapping's line if the key isn't found
+func keyLine(node *yaml.Node, key string) int {
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i].Line
+			}
+		}
+	}
+	return node.Line
+}
+
+// returns the items of a sequence node
+func sequence(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writes a config file to a temp dir and returns the diagnostics for it
+func validateYaml(t *testing.T, yaml string) []Diagnostic {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(yaml), 0600))
+	return ValidateConfig(path)
+}
+
+func Test_ValidateConfig_Example(t *testing.T) {
+	diagnostics := ValidateConfig(filepath.Join("..", "..", "config.example.yml"))
+	assert.False(t, HasErrors(diagnostics), diagnostics)
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	diagnostics := validateYaml(t, `global:
+  cooldown: 5
+  mqtt_hots: localhost
+garage_doors:
+  - name: main
+    circular_geofence:
+      center:
+        lat: 46.19290
+        lng: -123.79185
+      close_distance: 0.05
+      open_distance: 0.04
+    teslamate_geofence:
+      close_trigger:
+        from: home
+        to: not_home
+    cars:
+      - teslamate_car_id: 1
+  - name: side
+    polygon_geofence:
+      open:
+        - lat: 1
+          lng: 1
+        - lat: 2
+          lng: 2
+        - lat: 1
+          lng: 2
+        - lat: 2
+          lng: 1
+      close:
+        - lat: 1
+          lng: 1
+        - lat: 1
+          lng: 2
+    cars:
+      - teslamate_car_id: 1
+      - teslamate_car_id: 1
+`)
+	// car 1 on both garage doors is allowed, but not twice on the same one
+	assert.Equal(t, []Diagnostic{
+		{Line: 3, Severity: SeverityError, Message: "unknown key mqtt_hots, check its spelling and indentation"},
+		{Line: 5, Severity: SeverityError, Message: "garage door main defines circular_geofence and teslamate_geofence; only one geofence type may be defined, and circular_geofence would be used"},
+		{Line: 6, Severity: SeverityWarning, Message: "close_distance 0.05 is larger than open_distance 0.04 for garage door main, so the open geofence doesn't contain the close geofence"},
+		{Line: 20, Severity: SeverityWarning, Message: "open polygon for garage door side isn't closed; its last point will be joined to its first"},
+		{Line: 20, Severity: SeverityError, Message: "open polygon for garage door side crosses itself between points 1-2 and 3-4"},
+		{Line: 29, Severity: SeverityError, Message: "close polygon for garage door side has 2 distinct points, at least 3 are required"},
+		{Line: 36, Severity: SeverityError, Message: "duplicate teslamate_car_id 1 on garage door side, already defined on line 35"},
+	}, diagnostics)
+}
+
+func Test_ValidateConfig_UntaggedFields(t *testing.T) {
+	// runtime fields without a yaml tag aren't config keys
+	diagnostics := validateYaml(t, `testing: true
+garage_doors:
+  - name: main
+    oplock: true
+    teslamate_geofence:
+      close_trigger: {from: home, to: not_home}
+    cars:
+      - teslamate_car_id: 1
+        curdistance: 1
+`)
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Severity: SeverityError, Message: "unknown key testing, check its spelling and indentation"},
+		{Line: 4, Severity: SeverityError, Message: "unknown key oplock, check its spelling and indentation"},
+		{Line: 9, Severity: SeverityError, Message: "unknown key curdistance, check its spelling and indentation"},
+	}, diagnostics)
+}
+
+func Test_ValidateConfig_KMLPlacemarks(t *testing.T) {
+	kml := filepath.Join(t.TempDir(), "geofence.kml")
+	assert.NoError(t, os.WriteFile(kml, []byte(`<kml><Document><Placemark><name>close</name><Polygon><outerBoundaryIs><LinearRing><coordinates>
+		-123.7998,46.1929
+		-123.7998,46.1927
+		-123.7995,46.1927
+		-123.7998,46.1929
+	</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark></Document></kml>`), 0600))
+
+	diagnostics := validateYaml(t, `garage_doors:
+  - name: main
+    polygon_geofence:
+      kml_file: `+kml+`
+    cars:
+      - teslamate_car_id: 1
+`)
+	assert.False(t, HasErrors(diagnostics))
+	assert.Equal(t, []Diagnostic{
+		{Line: 4, Severity: SeverityWarning, Message: "kml file " + kml + " for garage door main has no placemark named open"},
+	}, diagnostics)
+}